@@ -0,0 +1,150 @@
+// Package apperr defines the errors resolvers return to clients. Each
+// carries a machine-readable code that is sent in the GraphQL error's
+// extensions, so clients don't have to match on messages.
+package apperr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+type Code string
+
+const (
+	Unauthenticated Code = "UNAUTHENTICATED"
+	Forbidden       Code = "FORBIDDEN"
+	NotFound        Code = "NOT_FOUND"
+	BadUserInput    Code = "BAD_USER_INPUT"
+	Conflict        Code = "CONFLICT"
+	RateLimited     Code = "RATE_LIMITED"
+	Timeout         Code = "TIMEOUT"
+	// Canceled means the client went away before the request finished.
+	Canceled         Code = "CANCELED"
+	Internal         Code = "INTERNAL"
+	ValidationFailed Code = "GRAPHQL_VALIDATION_FAILED"
+)
+
+type Error struct {
+	Code    Code
+	Message string
+	// CorrelationID ties a masked internal error to the server log entry
+	// holding the real cause.
+	CorrelationID string
+	cause         error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Extensions implements gqlerrors.ExtendedError.
+func (e *Error) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if e.CorrelationID != "" {
+		ext["correlationId"] = e.CorrelationID
+	}
+	return ext
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func NewUnauthenticated(message string) *Error {
+	return New(Unauthenticated, message)
+}
+
+func NewForbidden(message string) *Error {
+	return New(Forbidden, message)
+}
+
+func NewNotFound(message string) *Error {
+	return New(NotFound, message)
+}
+
+func NewBadUserInput(message string) *Error {
+	return New(BadUserInput, message)
+}
+
+func NewConflict(message string) *Error {
+	return New(Conflict, message)
+}
+
+func NewTimeout(message string) *Error {
+	return New(Timeout, message)
+}
+
+// Wrap turns err into a client error with the given code, keeping err's
+// message. An *Error is returned unchanged.
+func Wrap(code Code, err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Code: code, Message: err.Error(), cause: err}
+}
+
+// NewInternal hides err from the client. The cause is logged together with
+// a correlation ID that is also returned to the client. Errors caused by an
+// expired deadline become Timeout errors instead, and those caused by the
+// client canceling the request become Canceled errors; neither is logged.
+func NewInternal(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Code: Timeout, Message: "operation timed out", cause: err}
+	}
+	if errors.Is(err, context.Canceled) {
+		return &Error{Code: Canceled, Message: "request canceled", cause: err}
+	}
+	id := correlationID()
+	slog.Error("internal error", "correlation_id", id, "error", err)
+	return &Error{Code: Internal, Message: "internal server error", CorrelationID: id, cause: err}
+}
+
+// FormatError is used as the handler's FormatErrorFn. Errors that aren't
+// an *Error by the time they reach the client are masked as internal
+// errors; query validation errors get the ValidationFailed code.
+func FormatError(err error) gqlerrors.FormattedError {
+	if err == nil {
+		e := New(Internal, "internal server error")
+		formatted := gqlerrors.FormatError(e)
+		formatted.Extensions = e.Extensions()
+		return formatted
+	}
+	formatted := gqlerrors.FormatError(err)
+
+	// Errors that don't come from a field, such as the executor giving up
+	// when the request's context ends, arrive unwrapped.
+	original := err
+	if located, ok := err.(*gqlerrors.Error); ok {
+		original = located.OriginalError
+	}
+
+	switch original := original.(type) {
+	case nil:
+		formatted.Extensions = map[string]interface{}{"code": ValidationFailed}
+	case *Error:
+		formatted.Extensions = original.Extensions()
+	default:
+		internal := NewInternal(original)
+		formatted.Message = internal.Message
+		formatted.Extensions = internal.Extensions()
+	}
+	return formatted
+}
+
+func correlationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -9,9 +9,31 @@ import (
 
 var secretKey = []byte("supersecretkey")
 
-func GenerateToken(userID string) (string, error) {
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// ValidRole reports whether role is one of the roles above.
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// Identity is the authenticated caller of a request. Scopes is nil for
+// user sessions and lists the granted permissions for API keys.
+type Identity struct {
+	UserID string
+	Role   string
+	Scopes []string
+}
+
+// GenerateToken issues a JWT for the user. version is the user's current
+// token version; bumping it in the database revokes every older token.
+func GenerateToken(userID string, version int) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
+		"ver":     version,
 		"exp":     time.Now().Add(time.Hour * 72).Unix(),
 	}
 
@@ -19,7 +41,7 @@ func GenerateToken(userID string) (string, error) {
 	return token.SignedString(secretKey)
 }
 
-func ValidateToken(tokenString string) (string, error) {
+func ValidateToken(tokenString string) (string, int, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -28,18 +50,22 @@ func ValidateToken(tokenString string) (string, error) {
 	})
 
 	if err != nil || !token.Valid {
-		return "", errors.New("invalid token")
+		return "", 0, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", errors.New("invalid claims")
+		return "", 0, errors.New("invalid claims")
 	}
 
 	userID, ok := claims["user_id"].(string)
 	if !ok {
-		return "", errors.New("user_id not found")
+		return "", 0, errors.New("user_id not found")
 	}
 
-	return userID, nil
+	// Tokens issued before versioning was introduced have no "ver" claim
+	// and count as version 0.
+	version, _ := claims["ver"].(float64)
+
+	return userID, int(version), nil
 }
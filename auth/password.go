@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]struct{} {
+	m := map[string]struct{}{}
+	sc := bufio.NewScanner(strings.NewReader(commonPasswordList))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			m[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return m
+}()
+
+type PasswordPolicy struct {
+	MinLength      int
+	RejectCommon   bool
+	RejectUsername bool
+}
+
+var Policy = PasswordPolicy{
+	MinLength:      10,
+	RejectCommon:   true,
+	RejectUsername: true,
+}
+
+func (p PasswordPolicy) Validate(username, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if p.RejectCommon {
+		if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+			return errors.New("password is too common")
+		}
+	}
+	if p.RejectUsername && username != "" &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+	return nil
+}
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// HashConfig selects how new password hashes are made. Stored hashes that
+// were made differently are upgraded at the next successful login.
+type HashConfig struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+var Hashing = HashConfig{
+	Algorithm: AlgorithmArgon2id,
+	Argon2: Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	},
+	BcryptCost: 12,
+}
+
+func HashPassword(password string) (string, error) {
+	switch Hashing.Algorithm {
+	case AlgorithmBcrypt:
+		h, err := bcrypt.GenerateFromPassword([]byte(password), Hashing.BcryptCost)
+		return string(h), err
+	case AlgorithmArgon2id:
+		return hashArgon2id(password, Hashing.Argon2)
+	default:
+		return "", fmt.Errorf("unknown password hash algorithm %q", Hashing.Algorithm)
+	}
+}
+
+// VerifyPassword reports whether password matches hash, and whether hash
+// should be replaced because it doesn't match the current HashConfig.
+func VerifyPassword(hash, password string) (bool, bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		current := Hashing.Argon2
+		stale := Hashing.Algorithm != AlgorithmArgon2id ||
+			params.Memory != current.Memory ||
+			params.Iterations != current.Iterations ||
+			params.Parallelism != current.Parallelism ||
+			uint32(len(key)) != current.KeyLength
+		return true, stale, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, err
+	}
+	stale := Hashing.Algorithm != AlgorithmBcrypt || cost != Hashing.BcryptCost
+	return true, stale, nil
+}
+
+func hashArgon2id(password string, p Argon2Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, errors.New("invalid argon2id hash")
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errors.New("invalid argon2id hash")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errors.New("invalid argon2id hash")
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
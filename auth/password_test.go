@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheapHashing keeps the tests fast; only the parameters differ from the
+// defaults.
+func cheapHashing(t *testing.T, algorithm string) {
+	saved := Hashing
+	t.Cleanup(func() { Hashing = saved })
+	Hashing = HashConfig{
+		Algorithm:  algorithm,
+		Argon2:     Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		BcryptCost: bcrypt.MinCost,
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		username, password string
+		wantErr            string
+	}{
+		{"alice", "correct horse battery", ""},
+		{"alice", "short", "at least 10 characters"},
+		{"alice", "ÄÖÜäöüßéèê", ""},
+		{"alice", "password123", "too common"},
+		{"alice", "PassWord123", "too common"},
+		{"alice", "i am Alice really", "username"},
+		{"", "no username given", ""},
+	}
+	for _, tt := range tests {
+		err := Policy.Validate(tt.username, tt.password)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%q, %q) = %v, want nil", tt.username, tt.password, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%q, %q) = %v, want an error about %q", tt.username, tt.password, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCommonPasswordsWithLowerMinimum(t *testing.T) {
+	policy := Policy
+	policy.MinLength = 6
+	for _, password := range []string{"password", "12345678", "qwerty123", "Password1234"} {
+		if err := policy.Validate("alice", password); err == nil || !strings.Contains(err.Error(), "too common") {
+			t.Errorf("Validate(%q) with MinLength 6 = %v, want it rejected as too common", password, err)
+		}
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			cheapHashing(t, algorithm)
+
+			hash, err := HashPassword("correct horse battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, stale, err := VerifyPassword(hash, "correct horse battery")
+			if !ok || stale || err != nil {
+				t.Errorf("right password: ok=%v stale=%v err=%v, want ok and not stale", ok, stale, err)
+			}
+			ok, _, err = VerifyPassword(hash, "wrong horse battery")
+			if ok || err != nil {
+				t.Errorf("wrong password: ok=%v err=%v, want a mismatch", ok, err)
+			}
+		})
+	}
+}
+
+func TestVerifyFlagsStaleHashes(t *testing.T) {
+	cheapHashing(t, AlgorithmBcrypt)
+	bcryptHash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheapHashing(t, AlgorithmArgon2id)
+	argonHash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bcrypt hashes are upgraded once argon2id is configured.
+	ok, stale, err := VerifyPassword(bcryptHash, "correct horse battery")
+	if !ok || !stale || err != nil {
+		t.Errorf("bcrypt hash under argon2id: ok=%v stale=%v err=%v, want ok and stale", ok, stale, err)
+	}
+
+	// So are argon2id hashes made with other parameters.
+	Hashing.Argon2.Iterations++
+	ok, stale, err = VerifyPassword(argonHash, "correct horse battery")
+	if !ok || !stale || err != nil {
+		t.Errorf("argon2id hash with old parameters: ok=%v stale=%v err=%v, want ok and stale", ok, stale, err)
+	}
+
+	// A wrong password never asks for a rehash.
+	if _, stale, _ := VerifyPassword(bcryptHash, "wrong horse battery"); stale {
+		t.Error("wrong password reported the hash as stale")
+	}
+}
+
+func TestVerifyRejectsMalformedHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!$a2V5",
+	} {
+		if ok, _, err := VerifyPassword(hash, "correct horse battery"); ok || err == nil {
+			t.Errorf("VerifyPassword(%q) = %v, %v, want an error", hash, ok, err)
+		}
+	}
+}
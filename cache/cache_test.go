@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "books:a", []byte("a"), time.Minute)
+	c.Set(ctx, "books:b", []byte("b"), time.Minute)
+	c.Get(ctx, "books:a")
+	c.Set(ctx, "reviews:c", []byte("c"), time.Minute)
+
+	if _, ok := c.Get(ctx, "books:b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if v, ok := c.Get(ctx, "books:a"); !ok || string(v) != "a" {
+		t.Errorf("Get(books:a) = %q, %v", v, ok)
+	}
+
+	c.DeletePrefix(ctx, "books:")
+	if _, ok := c.Get(ctx, "books:a"); ok {
+		t.Error("DeletePrefix kept a matching key")
+	}
+	if _, ok := c.Get(ctx, "reviews:c"); !ok {
+		t.Error("DeletePrefix removed a key with another prefix")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get(ctx, "reviews:c"); ok {
+		t.Error("expired entry was returned")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d after expiry, want 0", c.Len())
+	}
+}
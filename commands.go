@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"grphqlserver/auth"
+	"grphqlserver/fixtures"
+	"grphqlserver/middleware"
+	"grphqlserver/resolvers"
+	"grphqlserver/sso"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// runWithStore configures the service, runs f and disconnects. Errors are
+// printed and give exit code 1.
+func runWithStore(timeout time.Duration, f func(ctx context.Context) error) int {
+	if err := configure(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer resolvers.Disconnect(context.Background())
+
+	if err := f(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// parseFlags parses args and rejects leftover positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) bool {
+	if err := flags.Parse(args); err != nil {
+		return false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q\n", flags.Arg(0))
+		flags.Usage()
+		return false
+	}
+	return true
+}
+
+func migrateCommand(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := flags.Bool("status", false, "list pending migrations without applying them")
+	if !parseFlags(flags, args) {
+		return 2
+	}
+
+	return runWithStore(5*time.Minute, func(ctx context.Context) error {
+		if *status {
+			pending, err := resolvers.PendingMigrations(ctx)
+			if err != nil {
+				return err
+			}
+			if len(pending) == 0 {
+				fmt.Println("Database is up to date.")
+			}
+			for _, id := range pending {
+				fmt.Println("pending", id)
+			}
+			return nil
+		}
+
+		applied, err := resolvers.Migrate(ctx)
+		for _, id := range applied {
+			fmt.Println("applied", id)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Database is up to date.")
+		}
+		return err
+	})
+}
+
+const userUsage = `usage:
+  grphqlserver user create -name NAME -email EMAIL [-role user|moderator|admin]
+  grphqlserver user reset-password -name NAME
+  grphqlserver user disable -name NAME
+  grphqlserver user enable -name NAME
+
+create and reset-password read the password from the first line of
+standard input.`
+
+func userCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, userUsage) }
+	name := flags.String("name", "", "the user's username")
+	var email, role *string
+	if args[0] == "create" {
+		email = flags.String("email", "", "the user's email address")
+		role = flags.String("role", auth.RoleUser, "user, moderator or admin")
+	}
+	if !parseFlags(flags, args[1:]) {
+		return 2
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "-name is required")
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		password, err := readPassword()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		return runWithStore(time.Minute, func(ctx context.Context) error {
+			// An operator vouches for the address, so no verification
+			// email is sent.
+			id, err := resolvers.CreateUser(ctx, resolvers.NewUser{
+				UserName:      *name,
+				Email:         *email,
+				Password:      password,
+				Role:          *role,
+				EmailVerified: true,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Created %s %s with ID %s.\n", *role, *name, id.Hex())
+			return nil
+		})
+	case "reset-password":
+		password, err := readPassword()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		return runWithStore(time.Minute, func(ctx context.Context) error {
+			if err := resolvers.SetPassword(ctx, *name, password); err != nil {
+				return err
+			}
+			fmt.Printf("Password of %s changed; existing sessions are revoked.\n", *name)
+			return nil
+		})
+	case "disable":
+		return runWithStore(time.Minute, func(ctx context.Context) error {
+			if err := resolvers.DisableUser(ctx, *name); err != nil {
+				return err
+			}
+			fmt.Printf("Disabled %s and revoked their sessions and API keys.\n", *name)
+			return nil
+		})
+	case "enable":
+		return runWithStore(time.Minute, func(ctx context.Context) error {
+			if err := resolvers.EnableUser(ctx, *name); err != nil {
+				return err
+			}
+			fmt.Printf("Enabled %s.\n", *name)
+			return nil
+		})
+	default:
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+}
+
+// readPassword reads one line from standard input, prompting when it is a
+// terminal. Passwords aren't taken as flags so they stay out of shell
+// history and process listings.
+func readPassword() (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password on standard input")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func seedCommand(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	file := flags.String("file", defaultFixtures, "fixture file to load")
+	reset := flags.Bool("reset", false, "empty the collections before loading")
+	if !parseFlags(flags, args) {
+		return 2
+	}
+	return runWithStore(5*time.Minute, func(ctx context.Context) error {
+		if !*reset {
+			empty, err := databaseEmpty(ctx)
+			if err != nil {
+				return err
+			}
+			if !empty {
+				return errors.New("the database already has data; use -reset to replace it")
+			}
+		}
+		refs, err := fixtures.LoadFile(ctx, resolvers.Store, *file, fixtures.Options{Reset: *reset})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Loaded %s (%d named records).\n", *file, len(refs))
+		return nil
+	})
+}
+
+const defaultFixtures = "fixtures/demo.json"
+
+// databaseEmpty reports whether none of the collections fixtures write to
+// has any documents.
+func databaseEmpty(ctx context.Context) (bool, error) {
+	for _, name := range fixtures.Collections {
+		n, err := resolvers.Store.Collection(name).CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func reindexCommand(args []string) int {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	drop := flags.Bool("drop", false, "drop existing indexes and rebuild them")
+	if !parseFlags(flags, args) {
+		return 2
+	}
+	return runWithStore(30*time.Minute, func(ctx context.Context) error {
+		return resolvers.Reindex(ctx, *drop)
+	})
+}
+
+// checkCommand validates the configuration the server would start with
+// and runs the readiness checks, so that a deployment can be verified
+// before traffic reaches it.
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	if !parseFlags(flags, args) {
+		return 2
+	}
+
+	failed := false
+	report := func(name string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("%-14s FAIL  %v\n", name, err)
+		} else {
+			fmt.Printf("%-14s ok\n", name)
+		}
+	}
+
+	err := configure()
+	report("config", err)
+	if err != nil {
+		return 1
+	}
+	defer resolvers.Disconnect(context.Background())
+
+	_, err = middleware.TimeoutsFromEnv()
+	report("timeouts", err)
+	_, err = middleware.CORSFromEnv()
+	report("cors", err)
+	_, err = middleware.TrustedProxiesFromEnv()
+	report("proxies", err)
+	_, err = graphql.NewSchema(defineSchema())
+	report("schema", err)
+
+	if cfg, ok := sso.ConfigFromEnv(); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := sso.New(ctx, cfg, resolvers.LinkOIDCUser)
+		cancel()
+		report("oidc", err)
+	}
+
+	checker := newChecker()
+	checker.Timeout = 10 * time.Second
+	result, _ := checker.Run(context.Background())
+	names := make([]string, 0, len(result.Checks))
+	for name := range result.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var err error
+		if res := result.Checks[name]; res.Status != "up" {
+			err = errors.New(res.Error)
+		}
+		report(name, err)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"grphqlserver/auth"
+	"grphqlserver/fixtures"
+	"grphqlserver/lockout"
+	"grphqlserver/middleware"
+	"grphqlserver/resolvers"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the end-to-end tests")
+
+// TestEndToEnd runs every testdata/e2e/*.graphql operation against the
+// server's /graphql handler, backed by a store.Memory loaded with
+// testdata/e2e/fixtures.json, and compares the response with the .json
+// golden file next to it. Run with -update to rewrite the golden files.
+//
+// Comment lines at the top of an operation file set up the request:
+//
+//	# as: alice
+//	# variables: {"id": "ref:gopl"}
+//
+// "as" sends a session token for a fixture user. Strings of the form
+// "ref:name" in variables are replaced by the fixture's ObjectID, and
+// ObjectIDs in responses are written back as refs, or as "id:N" for
+// records created by the operation. Tokens are written as "<token>".
+func TestEndToEnd(t *testing.T) {
+	defer func(h auth.HashConfig) { auth.Hashing = h }(auth.Hashing)
+	auth.Hashing.Argon2.Memory = 1024
+	auth.Hashing.Argon2.Iterations = 1
+
+	schema, err := newSchema(middleware.DefaultTimeouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := graphqlHandler(&schema, false, 1<<20)
+
+	files, err := filepath.Glob(filepath.Join("testdata", "e2e", "*.graphql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no operation files in testdata/e2e")
+	}
+
+	savedStore, savedGuard := resolvers.Store, resolvers.LoginGuard
+	t.Cleanup(func() {
+		resolvers.UseStore(savedStore)
+		resolvers.LoginGuard = savedGuard
+	})
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".graphql")
+		t.Run(name, func(t *testing.T) {
+			s := resolvers.NewMemoryStore()
+			resolvers.UseStore(s)
+			resolvers.LoginGuard = lockout.NewGuard(lockout.NewMemoryStore())
+			refs := fixtures.ForTest(t, s, filepath.Join("testdata", "e2e", "fixtures.json"))
+
+			req := readOperation(t, file, refs)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			got := normalize(t, w.Body.Bytes(), refs)
+
+			golden := strings.TrimSuffix(file, ".graphql") + ".json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v; run go test -run TestEndToEnd -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response differs from %s:\n--- got\n%s--- want\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// readOperation builds the POST request for an operation file.
+func readOperation(t *testing.T, file string, refs fixtures.Refs) *http.Request {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var as, variables string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "#")), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "as":
+			as = strings.TrimSpace(value)
+		case "variables":
+			variables = strings.TrimSpace(value)
+		}
+	}
+
+	body := map[string]interface{}{"query": string(data)}
+	if variables != "" {
+		var v interface{}
+		if err := json.Unmarshal([]byte(variables), &v); err != nil {
+			t.Fatalf("variables: %v", err)
+		}
+		body["variables"] = replaceRefs(t, v, refs)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if as != "" {
+		id, ok := refs[as]
+		if !ok {
+			t.Fatalf("as: no fixture user %q", as)
+		}
+		token, err := auth.GenerateToken(id.Hex(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func replaceRefs(t *testing.T, v interface{}, refs fixtures.Refs) interface{} {
+	switch v := v.(type) {
+	case string:
+		if name, ok := strings.CutPrefix(v, "ref:"); ok {
+			id, ok := refs[name]
+			if !ok {
+				t.Fatalf("variables: unknown ref %q", name)
+			}
+			return id.Hex()
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = replaceRefs(t, e, refs)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = replaceRefs(t, e, refs)
+		}
+	}
+	return v
+}
+
+var (
+	objectIDPattern = regexp.MustCompile(`"[0-9a-f]{24}"`)
+	tokenPattern    = regexp.MustCompile(`"eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+"`)
+)
+
+// normalize replaces the values that differ between runs and indents the
+// response.
+func normalize(t *testing.T, body []byte, refs fixtures.Refs) []byte {
+	t.Helper()
+	names := make(map[string]string, len(refs))
+	for name, id := range refs {
+		names[`"`+id.Hex()+`"`] = `"ref:` + name + `"`
+	}
+	created := map[string]string{}
+	body = objectIDPattern.ReplaceAllFunc(body, func(id []byte) []byte {
+		if name, ok := names[string(id)]; ok {
+			return []byte(name)
+		}
+		if _, ok := created[string(id)]; !ok {
+			created[string(id)] = fmt.Sprintf(`"id:%d"`, len(created)+1)
+		}
+		return []byte(created[string(id)])
+	})
+	body = tokenPattern.ReplaceAll(body, []byte(`"<token>"`))
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, bytes.TrimSpace(body), "", "  "); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, body)
+	}
+	out.WriteByte('\n')
+	return out.Bytes()
+}
@@ -0,0 +1,297 @@
+// Package fixtures loads users, books, reviews and shelves described in a
+// JSON file into a store. Records name each other by symbolic refs instead of
+// ObjectIDs, so a file can be written by hand:
+//
+//	{
+//	  "users":   [{"ref": "alice", "userName": "alice", "email": "alice@example.com", "password": "..."}],
+//	  "books":   [{"ref": "gopl", "title": "The Go Programming Language", "author": "Donovan"}],
+//	  "reviews": [{"book": "gopl", "user": "alice", "rating": 5, "comment": "Thorough."}],
+//	  "shelves": [{"book": "gopl", "user": "alice", "shelf": "READ"}]
+//	}
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"grphqlserver/auth"
+	"grphqlserver/resolvers"
+	"grphqlserver/store"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// File is the content of a fixture file.
+type File struct {
+	Users   []User   `json:"users"`
+	Books   []Book   `json:"books"`
+	Reviews []Review `json:"reviews"`
+	Shelves []Shelf  `json:"shelves"`
+}
+
+// User is stored with its password hashed by the configured algorithm.
+// Role defaults to user and EmailVerified to true.
+type User struct {
+	Ref           string `json:"ref"`
+	UserName      string `json:"userName"`
+	Email         string `json:"email"`
+	Password      string `json:"password"`
+	Role          string `json:"role"`
+	DisplayName   string `json:"displayName"`
+	Bio           string `json:"bio"`
+	EmailVerified *bool  `json:"emailVerified"`
+}
+
+type Book struct {
+	Ref    string `json:"ref"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+}
+
+// Review refers to its book and, optionally, its author by ref. Date
+// defaults to the time of loading.
+type Review struct {
+	Ref     string    `json:"ref"`
+	Book    string    `json:"book"`
+	User    string    `json:"user"`
+	Rating  int       `json:"rating"`
+	Comment string    `json:"comment"`
+	Date    time.Time `json:"date"`
+}
+
+// Shelf puts a book on one of a user's shelves, built-in or custom.
+// AddedAt defaults to the time of loading.
+type Shelf struct {
+	Book    string    `json:"book"`
+	User    string    `json:"user"`
+	Shelf   string    `json:"shelf"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// Refs maps the refs in a file to the ObjectIDs given to the records.
+// Users, books and reviews share one namespace.
+type Refs map[string]primitive.ObjectID
+
+type Options struct {
+	// Reset empties the collections fixtures write to, and those holding
+	// per-user tokens and keys, before loading.
+	Reset bool
+}
+
+// Collections are the collections that Reset empties.
+var Collections = []string{"users", "books", "reviews", "shelves", "api_keys", "email_verifications", "password_resets"}
+
+// Parse reads a fixture file and checks that its refs resolve.
+func Parse(r io.Reader) (*File, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var f File
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	if _, err := f.assignIDs(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ReadFile parses the fixture file at path.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// assignIDs gives every record a new ObjectID and checks that refs are
+// unique and that reviews and shelves only refer to records in the file.
+func (f *File) assignIDs() (Refs, error) {
+	refs := Refs{}
+	add := func(kind string, i int, ref string) (primitive.ObjectID, error) {
+		id := primitive.NewObjectID()
+		if ref == "" {
+			return id, nil
+		}
+		if _, dup := refs[ref]; dup {
+			return id, fmt.Errorf("%s[%d]: ref %q is used twice", kind, i, ref)
+		}
+		refs[ref] = id
+		return id, nil
+	}
+
+	users := map[string]bool{}
+	for i, u := range f.Users {
+		if u.UserName == "" {
+			return nil, fmt.Errorf("users[%d]: userName is required", i)
+		}
+		if _, err := add("users", i, u.Ref); err != nil {
+			return nil, err
+		}
+		users[u.Ref] = true
+	}
+	books := map[string]bool{}
+	for i, b := range f.Books {
+		if _, err := add("books", i, b.Ref); err != nil {
+			return nil, err
+		}
+		books[b.Ref] = true
+	}
+	for i, r := range f.Reviews {
+		if !books[r.Book] || r.Book == "" {
+			return nil, fmt.Errorf("reviews[%d]: book %q is not a book ref", i, r.Book)
+		}
+		if r.User != "" && !users[r.User] {
+			return nil, fmt.Errorf("reviews[%d]: user %q is not a user ref", i, r.User)
+		}
+		if _, err := add("reviews", i, r.Ref); err != nil {
+			return nil, err
+		}
+	}
+	for i, s := range f.Shelves {
+		if !books[s.Book] || s.Book == "" {
+			return nil, fmt.Errorf("shelves[%d]: book %q is not a book ref", i, s.Book)
+		}
+		if !users[s.User] || s.User == "" {
+			return nil, fmt.Errorf("shelves[%d]: user %q is not a user ref", i, s.User)
+		}
+		if _, err := resolvers.ShelfName(s.Shelf); err != nil {
+			return nil, fmt.Errorf("shelves[%d]: %w", i, err)
+		}
+	}
+	return refs, nil
+}
+
+// Load writes the file's records to s and returns the IDs they were given.
+func Load(ctx context.Context, s store.Store, f *File, opts Options) (Refs, error) {
+	refs, err := f.assignIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Reset {
+		for _, name := range Collections {
+			if _, err := s.Collection(name).DeleteMany(ctx, bson.M{}); err != nil {
+				return nil, fmt.Errorf("resetting %s: %w", name, err)
+			}
+		}
+	}
+
+	// Records without a ref still need an ID; give them one here.
+	id := func(ref string) primitive.ObjectID {
+		if ref == "" {
+			return primitive.NewObjectID()
+		}
+		return refs[ref]
+	}
+
+	for i, u := range f.Users {
+		email, ok := resolvers.NormalizeEmail(u.Email)
+		if !ok {
+			return nil, fmt.Errorf("users[%d]: invalid email %q", i, u.Email)
+		}
+		role := u.Role
+		if role == "" {
+			role = auth.RoleUser
+		}
+		if !auth.ValidRole(role) {
+			return nil, fmt.Errorf("users[%d]: unknown role %q", i, role)
+		}
+		hash, err := auth.HashPassword(u.Password)
+		if err != nil {
+			return nil, fmt.Errorf("users[%d]: %w", i, err)
+		}
+		verified := u.EmailVerified == nil || *u.EmailVerified
+
+		doc := bson.M{
+			"_id":           id(u.Ref),
+			"userName":      u.UserName,
+			"password":      hash,
+			"email":         email,
+			"emailVerified": verified,
+			"role":          role,
+		}
+		if u.DisplayName != "" {
+			doc["displayName"] = u.DisplayName
+		}
+		if u.Bio != "" {
+			doc["bio"] = u.Bio
+		}
+		if _, err := s.Collection("users").InsertOne(ctx, doc); err != nil {
+			return nil, fmt.Errorf("users[%d]: %w", i, err)
+		}
+	}
+
+	for i, b := range f.Books {
+		doc := bson.M{"_id": id(b.Ref), "title": b.Title, "author": b.Author}
+		if _, err := s.Collection("books").InsertOne(ctx, doc); err != nil {
+			return nil, fmt.Errorf("books[%d]: %w", i, err)
+		}
+	}
+
+	now := time.Now()
+	for i, r := range f.Reviews {
+		date := r.Date
+		if date.IsZero() {
+			date = now
+		}
+		doc := bson.M{
+			"_id":     id(r.Ref),
+			"bookID":  refs[r.Book],
+			"rating":  r.Rating,
+			"comment": r.Comment,
+			"date":    date,
+		}
+		if r.User != "" {
+			doc["userID"] = refs[r.User]
+		}
+		if _, err := s.Collection("reviews").InsertOne(ctx, doc); err != nil {
+			return nil, fmt.Errorf("reviews[%d]: %w", i, err)
+		}
+	}
+
+	for i, sh := range f.Shelves {
+		addedAt := sh.AddedAt
+		if addedAt.IsZero() {
+			addedAt = now
+		}
+		shelf, _ := resolvers.ShelfName(sh.Shelf)
+		doc := resolvers.ShelfEntry(refs[sh.User], refs[sh.Book], shelf, addedAt)
+		doc["_id"] = primitive.NewObjectID()
+		if _, err := s.Collection("shelves").InsertOne(ctx, doc); err != nil {
+			return nil, fmt.Errorf("shelves[%d]: %w", i, err)
+		}
+	}
+
+	return refs, nil
+}
+
+// LoadFile reads and loads the fixture file at path.
+func LoadFile(ctx context.Context, s store.Store, path string, opts Options) (Refs, error) {
+	f, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(ctx, s, f, opts)
+}
+
+// ForTest resets s and loads the fixture file at path, failing the test
+// on any error.
+func ForTest(tb testing.TB, s store.Store, path string) Refs {
+	tb.Helper()
+	refs, err := LoadFile(context.Background(), s, path, Options{Reset: true})
+	if err != nil {
+		tb.Fatalf("loading fixtures: %v", err)
+	}
+	return refs
+}
@@ -0,0 +1,93 @@
+package fixtures
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"grphqlserver/auth"
+	"grphqlserver/store"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recorder is a store that keeps inserted documents per collection.
+type recorder struct {
+	docs map[string][]bson.M
+}
+
+func (r *recorder) Collection(name string) store.Collection {
+	return recordingCollection{r: r, name: name}
+}
+func (r *recorder) Ping(context.Context) error  { return nil }
+func (r *recorder) Close(context.Context) error { return nil }
+
+type recordingCollection struct {
+	store.Collection
+	r    *recorder
+	name string
+}
+
+func (c recordingCollection) InsertOne(_ context.Context, doc interface{}, _ ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	m := doc.(bson.M)
+	c.r.docs[c.name] = append(c.r.docs[c.name], m)
+	return &mongo.InsertOneResult{InsertedID: m["_id"]}, nil
+}
+
+func (c recordingCollection) DeleteMany(context.Context, interface{}, ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	n := len(c.r.docs[c.name])
+	delete(c.r.docs, c.name)
+	return &mongo.DeleteResult{DeletedCount: int64(n)}, nil
+}
+
+func TestLoadResolvesRefs(t *testing.T) {
+	defer func(h auth.HashConfig) { auth.Hashing = h }(auth.Hashing)
+	auth.Hashing.Argon2.Memory = 1024
+	auth.Hashing.Argon2.Iterations = 1
+
+	s := &recorder{docs: map[string][]bson.M{"books": {{"title": "stale"}}}}
+	refs := ForTest(t, s, "demo.json")
+
+	if n := len(s.docs["books"]); n != 4 {
+		t.Fatalf("%d books after reset and load, want 4", n)
+	}
+	byBook := map[interface{}]int{}
+	for _, review := range s.docs["reviews"] {
+		byBook[review["bookID"]]++
+	}
+	if byBook[refs["ddia"]] != 2 || byBook[refs["gopl"]] != 1 {
+		t.Errorf("reviews per book = %v, want 2 for ddia and 1 for gopl", byBook)
+	}
+	if first := s.docs["reviews"][0]; first["userID"] != refs["alice"] {
+		t.Errorf("first review's userID = %v, want alice's %v", first["userID"], refs["alice"])
+	}
+	for _, user := range s.docs["users"] {
+		match, _, err := auth.VerifyPassword(user["password"].(string), "correct horse battery")
+		if err != nil || !match {
+			t.Errorf("password of %s was not hashed from the fixture", user["userName"])
+		}
+	}
+	if s.docs["users"][0]["_id"] != refs["admin"] || s.docs["users"][0]["role"] != auth.RoleAdmin {
+		t.Errorf("admin = %v", s.docs["users"][0])
+	}
+}
+
+func TestParseRejectsBadRefs(t *testing.T) {
+	tests := map[string]string{
+		`{"books": [{"ref": "a"}], "reviews": [{"book": "b"}]}`:                                                                         `book "b"`,
+		`{"books": [{"ref": "a"}], "reviews": [{"book": "a", "user": "nobody"}]}`:                                                       `user "nobody"`,
+		`{"users": [{"ref": "a", "userName": "a"}], "books": [{"ref": "a"}]}`:                                                           `ref "a" is used twice`,
+		`{"users": [{"ref": "a"}]}`:                                                                                                     `userName is required`,
+		`{"books": [{"ref": "a", "isbn": "123"}]}`:                                                                                      `unknown field`,
+		`{"users": [{"ref": "u", "userName": "u"}], "books": [{"ref": "a"}], "shelves": [{"book": "a", "user": "u", "shelf": "read"}]}`: `reserved`,
+		`{"books": [{"ref": "a"}], "shelves": [{"book": "a", "user": "nobody", "shelf": "READ"}]}`:                                      `user "nobody"`,
+	}
+	for input, want := range tests {
+		_, err := Parse(strings.NewReader(input))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%s) = %v, want error containing %q", input, err, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package health serves the liveness and readiness endpoints used by the
+// orchestrator.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Check reports whether a dependency is usable. It should respect the
+// context's deadline.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks. It reports unready once Shutdown has
+// been called so that load balancers stop routing new requests before the
+// server stops accepting them.
+type Checker struct {
+	// Timeout bounds each check. Zero means two seconds.
+	Timeout time.Duration
+
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers a dependency check under name. It must be called before
+// the readiness handler serves requests.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Shutdown marks the service as unready for the rest of its life.
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// CheckResult is the outcome of one dependency check.
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report is the JSON body of the readiness endpoint.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Run executes every check concurrently and returns the report and whether
+// all of them passed.
+func (c *Checker) Run(ctx context.Context) (Report, bool) {
+	if c.shuttingDown.Load() {
+		return Report{Status: "shutting_down"}, false
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	results := make(map[string]CheckResult, len(c.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := nc.check(ctx)
+			res := CheckResult{Status: "up", DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				res.Status = "down"
+				res.Error = err.Error()
+			}
+			mu.Lock()
+			results[nc.name] = res
+			mu.Unlock()
+		}(nc)
+	}
+	wg.Wait()
+
+	ok := true
+	for _, res := range results {
+		if res.Status != "up" {
+			ok = false
+		}
+	}
+	status := "ready"
+	if !ok {
+		status = "unready"
+	}
+	return Report{Status: status, Checks: results}, ok
+}
+
+// ReadinessHandler responds 200 when every check passes and 503 otherwise,
+// with the per-dependency status as JSON.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep, ok := c.Run(r.Context())
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, rep)
+	})
+}
+
+// LivenessHandler responds 200 as long as the process can serve HTTP. It
+// deliberately checks no dependencies, so an outage of Mongo doesn't get
+// the process restarted.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Report{Status: "ok"})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,267 @@
+// Package httpcache makes GraphQL query responses cacheable by browsers
+// and CDNs. Fields carry cache hints; a response gets a Cache-Control
+// header allowing the shortest max-age of the fields it contains, and GET
+// responses get an ETag so that clients can revalidate them.
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"grphqlserver/apperr"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type Scope int
+
+const (
+	// Public responses may be stored by shared caches such as CDNs.
+	Public Scope = iota
+	// Private responses depend on the caller and may only be stored by
+	// the caller's browser.
+	Private
+)
+
+// Hint says how long a field's value may be cached.
+type Hint struct {
+	MaxAge time.Duration
+	Scope  Scope
+}
+
+// Hints maps "Type.field" to the field's hint. Fields with a resolver of
+// their own and no hint make a response uncacheable; fields read from
+// their parent object take the parent's hint.
+type Hints map[string]Hint
+
+// policy collects the hints of the fields resolved for one request.
+type policy struct {
+	mu       sync.Mutex
+	seen     bool
+	maxAge   time.Duration
+	private  bool
+	noCache  bool
+	mutation bool
+}
+
+type policyKey struct{}
+
+func (p *policy) add(h Hint, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !ok || h.MaxAge <= 0 {
+		p.noCache = true
+		return
+	}
+	if !p.seen || h.MaxAge < p.maxAge {
+		p.maxAge = h.MaxAge
+	}
+	p.seen = true
+	if h.Scope == Private {
+		p.private = true
+	}
+}
+
+func (p *policy) header() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.seen || p.noCache || p.mutation {
+		return "no-store"
+	}
+	scope := "public"
+	if p.private {
+		scope = "private"
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, int(p.maxAge.Seconds()))
+}
+
+// Extension is a graphql-go schema extension that records the hint of
+// every field with a resolver of its own.
+type Extension struct {
+	Hints Hints
+}
+
+var _ graphql.Extension = Extension{}
+
+func (Extension) Init(ctx context.Context, _ *graphql.Params) context.Context {
+	return ctx
+}
+
+func (Extension) Name() string {
+	return "cacheControl"
+}
+
+func (Extension) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(error) {}
+}
+
+func (Extension) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func([]gqlerrors.FormattedError) {}
+}
+
+func (Extension) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	return ctx, func(result *graphql.Result) {
+		if p, ok := ctx.Value(policyKey{}).(*policy); ok && result.HasErrors() {
+			p.add(Hint{}, false)
+		}
+	}
+}
+
+func (e Extension) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	noop := func(interface{}, error) {}
+	p, ok := ctx.Value(policyKey{}).(*policy)
+	if !ok {
+		return ctx, noop
+	}
+	if op, ok := info.Operation.(*ast.OperationDefinition); ok && op.Operation != ast.OperationTypeQuery {
+		p.mu.Lock()
+		p.mutation = true
+		p.mu.Unlock()
+		return ctx, noop
+	}
+	if !hasResolver(info) {
+		return ctx, noop
+	}
+	hint, ok := e.Hints[info.ParentType.Name()+"."+info.FieldName]
+	p.add(hint, ok)
+	return ctx, noop
+}
+
+func (Extension) HasResult() bool {
+	return false
+}
+
+func (Extension) GetResult(context.Context) interface{} {
+	return nil
+}
+
+func hasResolver(info *graphql.ResolveInfo) bool {
+	obj, ok := info.ParentType.(*graphql.Object)
+	if !ok {
+		return false
+	}
+	def, ok := obj.Fields()[info.FieldName]
+	return ok && def.Resolve != nil
+}
+
+// Middleware only lets GET requests run queries, sets Cache-Control from
+// the hints of the fields resolved, and answers GET requests whose
+// If-None-Match matches the response's ETag with 304 Not Modified.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && isMutation(r) {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed,
+				apperr.NewBadUserInput("only queries can be sent with GET; use POST for mutations"))
+			return
+		}
+
+		p := &policy{}
+		buf := &bufferedWriter{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(buf, r.WithContext(context.WithValue(r.Context(), policyKey{}, p)))
+
+		for k, v := range buf.header {
+			w.Header()[k] = v
+		}
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", p.header())
+		}
+
+		if r.Method == http.MethodGet && buf.status == http.StatusOK {
+			sum := sha256.Sum256(buf.body.Bytes())
+			etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+			w.Header().Set("ETag", etag)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Type")
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
+
+// isMutation reports whether the GET request's operation is anything but
+// a query. Requests that don't parse are left to the handler to reject.
+func isMutation(r *http.Request) bool {
+	values := r.URL.Query()
+	query := values.Get("query")
+	if query == "" {
+		return false
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	name := values.Get("operationName")
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" || (op.Name != nil && op.Name.Value == name) {
+			if op.Operation != ast.OperationTypeQuery {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []gqlerrors.FormattedError{apperr.FormatError(err)},
+	})
+}
+
+// bufferedWriter holds the response back so that headers depending on the
+// whole body can still be set.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (b *bufferedWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if !b.wrote {
+		b.status, b.wrote = status, true
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
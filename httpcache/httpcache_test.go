@@ -0,0 +1,112 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/handler"
+)
+
+func testHandler(t *testing.T) http.Handler {
+	t.Helper()
+	resolve := func(v interface{}) graphql.FieldResolveFn {
+		return func(graphql.ResolveParams) (interface{}, error) { return v, nil }
+	}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"catalog":  &graphql.Field{Type: graphql.String, Resolve: resolve("books")},
+				"news":     &graphql.Field{Type: graphql.String, Resolve: resolve("today")},
+				"me":       &graphql.Field{Type: graphql.String, Resolve: resolve("alice")},
+				"unhinted": &graphql.Field{Type: graphql.String, Resolve: resolve("x")},
+			},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"touch": &graphql.Field{Type: graphql.Boolean, Resolve: resolve(true)},
+			},
+		}),
+		Extensions: []graphql.Extension{Extension{Hints: Hints{
+			"Query.catalog": {MaxAge: time.Minute, Scope: Public},
+			"Query.news":    {MaxAge: 10 * time.Second, Scope: Public},
+			"Query.me":      {MaxAge: 30 * time.Second, Scope: Private},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Middleware(handler.New(&handler.Config{Schema: &schema}))
+}
+
+func get(h http.Handler, query string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(query), nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCacheControl(t *testing.T) {
+	h := testHandler(t)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"{ catalog }", "public, max-age=60"},
+		{"{ catalog news }", "public, max-age=10"},
+		{"{ catalog me }", "private, max-age=30"},
+		{"{ catalog unhinted }", "no-store"},
+		{"{ __typename }", "no-store"},
+		{"{ missing }", "no-store"},
+	}
+	for _, tt := range tests {
+		if got := get(h, tt.query, nil).Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	h := testHandler(t)
+	first := get(h, "{ catalog }", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status %d, ETag %q", first.Code, etag)
+	}
+
+	again := get(h, "{ catalog }", http.Header{"If-None-Match": {etag}})
+	if again.Code != http.StatusNotModified || again.Body.Len() != 0 {
+		t.Errorf("revalidation: status %d with %d bytes, want 304 and no body", again.Code, again.Body.Len())
+	}
+
+	other := get(h, "{ news }", http.Header{"If-None-Match": {etag}})
+	if other.Code != http.StatusOK {
+		t.Errorf("different response: status %d, want 200", other.Code)
+	}
+}
+
+func TestMutationOverGET(t *testing.T) {
+	h := testHandler(t)
+	for _, query := range []string{"mutation { touch }", "query A { catalog } mutation B { touch }"} {
+		w := get(h, query, nil)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: status %d, want 405", query, w.Code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/graphql?operationName=A&query="+
+		url.QueryEscape("query A { catalog } mutation B { touch }"), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("query selected by operationName: status %d, want 200", w.Code)
+	}
+}
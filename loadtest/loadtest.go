@@ -0,0 +1,520 @@
+// Package loadtest replays a weighted mix of GraphQL operations against a
+// server and measures throughput, latency and errors.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Book is a book of the target's catalog that operations can refer to.
+type Book struct {
+	ID    string `json:"_id"`
+	Title string `json:"title"`
+}
+
+// Operation is one kind of request in the mix.
+type Operation struct {
+	Name  string
+	Query string
+	// Variables returns the variables of one request. It may be nil.
+	Variables func(r *mathrand.Rand, books []Book) map[string]interface{}
+	// Auth sends the virtual user's session token.
+	Auth bool
+	// NeedsBooks makes Run read the target's books first and fail if
+	// there are none.
+	NeedsBooks bool
+}
+
+// Operations are the operations a mix can name.
+var Operations = map[string]Operation{
+	"books": {
+		Name:  "books",
+		Query: `query Books { books { _id title author } }`,
+	},
+	"findBooks": {
+		Name:  "findBooks",
+		Query: `query FindBooks($title: String) { findBooks(title: $title) { _id title author } }`,
+		Variables: func(r *mathrand.Rand, books []Book) map[string]interface{} {
+			words := strings.Fields(books[r.Intn(len(books))].Title)
+			// The title is matched as a regular expression.
+			return map[string]interface{}{"title": regexp.QuoteMeta(words[r.Intn(len(words))])}
+		},
+		NeedsBooks: true,
+	},
+	"findReviews": {
+		Name:  "findReviews",
+		Query: `query FindReviews($bookID: BSON) { findReviews(bookID: $bookID) { _id rating comment date } }`,
+		Variables: func(r *mathrand.Rand, books []Book) map[string]interface{} {
+			return map[string]interface{}{"bookID": books[r.Intn(len(books))].ID}
+		},
+		NeedsBooks: true,
+	},
+	"me": {
+		Name:  "me",
+		Query: `query Me { me { _id userName } }`,
+		Auth:  true,
+	},
+	"addReview": {
+		Name:  "addReview",
+		Query: `mutation AddReview($input: ReviewInput) { addReview(input: $input) { _id } }`,
+		Variables: func(r *mathrand.Rand, books []Book) map[string]interface{} {
+			return map[string]interface{}{"input": map[string]interface{}{
+				"bookID":  books[r.Intn(len(books))].ID,
+				"rating":  1 + r.Intn(5),
+				"comment": "load test review",
+			}}
+		},
+		Auth:       true,
+		NeedsBooks: true,
+	},
+}
+
+// Weighted is an operation and its share of the mix.
+type Weighted struct {
+	Operation Operation
+	Weight    int
+}
+
+// ParseMix reads a mix such as "findReviews=8,books=1,me=1". An operation
+// without a weight counts once.
+func ParseMix(s string) ([]Weighted, error) {
+	var mix []Weighted
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, weight, hasWeight := strings.Cut(part, "=")
+		op, ok := Operations[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q", name)
+		}
+		w := 1
+		if hasWeight {
+			n, err := strconv.Atoi(weight)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid weight %q for %s", weight, name)
+			}
+			w = n
+		}
+		if w > 0 {
+			mix = append(mix, Weighted{Operation: op, Weight: w})
+		}
+	}
+	if len(mix) == 0 {
+		return nil, errors.New("the mix has no operations")
+	}
+	return mix, nil
+}
+
+// Config describes a run.
+type Config struct {
+	// Target is the URL of the /graphql endpoint.
+	Target string
+	Mix    []Weighted
+	// Concurrency is the number of workers sending requests.
+	Concurrency int
+	// Rate caps the requests per second across all workers; 0 means as
+	// fast as the workers go.
+	Rate float64
+	// Duration is how long requests are sent, after the virtual users
+	// have been set up.
+	Duration time.Duration
+	// Users is the number of virtual users registered for operations that
+	// need authentication. It defaults to Concurrency.
+	Users int
+	// Timeout bounds each request.
+	Timeout time.Duration
+}
+
+// Run sets up virtual users, then sends requests for cfg.Duration or until
+// ctx is done, and reports what happened.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Users <= 0 {
+		cfg.Users = cfg.Concurrency
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	c := &client{
+		http:   &http.Client{Timeout: cfg.Timeout},
+		target: cfg.Target,
+		report: newReport(),
+	}
+
+	needsAuth, needsBooks := false, false
+	for _, w := range cfg.Mix {
+		needsAuth = needsAuth || w.Operation.Auth
+		needsBooks = needsBooks || w.Operation.NeedsBooks
+	}
+
+	var books []Book
+	if needsBooks {
+		var err error
+		if books, err = c.books(ctx); err != nil {
+			return nil, fmt.Errorf("reading the catalog: %w", err)
+		}
+		if len(books) == 0 {
+			return nil, errors.New("the target has no books; seed it first")
+		}
+	}
+
+	var tokens []string
+	if needsAuth {
+		var err error
+		if tokens, err = c.virtualUsers(ctx, cfg.Users, cfg.Concurrency); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var pace <-chan time.Time
+	if interval := time.Duration(float64(time.Second) / cfg.Rate); cfg.Rate > 0 && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	total := 0
+	for _, w := range cfg.Mix {
+		total += w.Weight
+	}
+
+	c.report.start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			r := mathrand.New(mathrand.NewSource(time.Now().UnixNano() + int64(worker)))
+			for {
+				if pace != nil {
+					select {
+					case <-pace:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				op := pick(cfg.Mix, total, r)
+				var vars map[string]interface{}
+				if op.Variables != nil {
+					vars = op.Variables(r, books)
+				}
+				token := ""
+				if op.Auth {
+					token = tokens[r.Intn(len(tokens))]
+				}
+				c.do(ctx, op.Name, op.Query, vars, token)
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.report.Elapsed = time.Since(c.report.start)
+	return c.report, nil
+}
+
+func pick(mix []Weighted, total int, r *mathrand.Rand) Operation {
+	n := r.Intn(total)
+	for _, w := range mix {
+		if n < w.Weight {
+			return w.Operation
+		}
+		n -= w.Weight
+	}
+	return mix[len(mix)-1].Operation
+}
+
+type client struct {
+	http   *http.Client
+	target string
+	report *Report
+}
+
+type response struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// failure describes why a request failed; res is nil when there was no
+// GraphQL response.
+func (res *response) failure() string {
+	if res == nil || len(res.Errors) == 0 {
+		return "the request failed"
+	}
+	return res.Errors[0].Message
+}
+
+// do sends one request, records its latency and outcome under name and
+// returns the response, if any, and whether it had no errors.
+func (c *client) do(ctx context.Context, name, query string, vars map[string]interface{}, token string) (*response, bool) {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": vars})
+	if err != nil {
+		panic(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.target, bytes.NewReader(body))
+	if err != nil {
+		c.report.record(name, 0, "REQUEST")
+		return nil, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	start := time.Now()
+	res, err := c.http.Do(req)
+	if err != nil {
+		// Requests cut off by the end of the run aren't failures.
+		if ctx.Err() == nil {
+			c.report.record(name, time.Since(start), "NETWORK")
+		}
+		return nil, false
+	}
+	defer res.Body.Close()
+
+	var out response
+	decodeErr := json.NewDecoder(res.Body).Decode(&out)
+	latency := time.Since(start)
+
+	switch {
+	case res.StatusCode != http.StatusOK:
+		c.report.record(name, latency, "HTTP_"+strconv.Itoa(res.StatusCode))
+	case decodeErr != nil:
+		c.report.record(name, latency, "INVALID_RESPONSE")
+	case len(out.Errors) > 0:
+		code := out.Errors[0].Extensions.Code
+		if code == "" {
+			code = "UNKNOWN"
+		}
+		c.report.record(name, latency, code)
+	default:
+		c.report.record(name, latency, "")
+		return &out, true
+	}
+	return &out, false
+}
+
+func (c *client) books(ctx context.Context) ([]Book, error) {
+	res, ok := c.do(ctx, "books", `query Books { books { _id title } }`, nil, "")
+	if !ok {
+		return nil, errors.New(res.failure())
+	}
+	var books []Book
+	if err := json.Unmarshal(res.Data["books"], &books); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// virtualUsers registers n users and logs each of them in, parallel
+// requests at a time, and returns their session tokens.
+func (c *client) virtualUsers(ctx context.Context, n, parallel int) ([]string, error) {
+	run := make([]byte, 4)
+	rand.Read(run)
+	prefix := "loadtest" + hex.EncodeToString(run)
+
+	tokens := make([]string, n)
+	errs := make([]error, n)
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			tokens[i], errs[i] = c.virtualUser(ctx, fmt.Sprintf("%s-%d", prefix, i))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("setting up virtual users: %w", err)
+		}
+	}
+	return tokens, nil
+}
+
+func (c *client) virtualUser(ctx context.Context, name string) (string, error) {
+	secret := make([]byte, 12)
+	rand.Read(secret)
+	password := "pw-" + hex.EncodeToString(secret)
+	input := map[string]interface{}{
+		"userName": name,
+		"email":    name + "@loadtest.invalid",
+		"password": password,
+	}
+
+	res, ok := c.do(ctx, "registerUser",
+		`mutation Register($input: UserInput) { registerUser(input: $input) }`,
+		map[string]interface{}{"input": input}, "")
+	if !ok {
+		return "", fmt.Errorf("registering %s: %s", name, res.failure())
+	}
+
+	delete(input, "email")
+	res, ok = c.do(ctx, "loginUser",
+		`mutation Login($input: UserInput) { loginUser(input: $input) }`,
+		map[string]interface{}{"input": input}, "")
+	if !ok {
+		return "", fmt.Errorf("logging in %s: %s", name, res.failure())
+	}
+	var token string
+	if err := json.Unmarshal(res.Data["loginUser"], &token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Report holds the outcome of a run per operation. Setup requests such as
+// registerUser are included but don't count towards the run's duration.
+type Report struct {
+	Elapsed time.Duration
+	start   time.Time
+
+	mu         sync.Mutex
+	Operations map[string]*Stats
+}
+
+// Stats are the results of one operation. Latencies include failed
+// requests.
+type Stats struct {
+	Requests  int
+	Errors    map[string]int
+	latencies []time.Duration
+	// measured counts the requests sent during the run, after setup.
+	measured int
+}
+
+func newReport() *Report {
+	return &Report{Operations: map[string]*Stats{}}
+}
+
+func (r *Report) record(name string, latency time.Duration, code string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.Operations[name]
+	if !ok {
+		s = &Stats{Errors: map[string]int{}}
+		r.Operations[name] = s
+	}
+	s.Requests++
+	if !r.start.IsZero() {
+		s.measured++
+	}
+	if latency > 0 {
+		s.latencies = append(s.latencies, latency)
+	}
+	if code != "" {
+		s.Errors[code]++
+	}
+}
+
+// Percentile returns the latency below which p percent of the requests
+// completed.
+func (s *Stats) Percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Throughput returns the requests per second of the operation during the
+// run.
+func (r *Report) Throughput(name string) float64 {
+	s, ok := r.Operations[name]
+	if !ok || r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.measured) / r.Elapsed.Seconds()
+}
+
+// String formats the report as a table.
+func (r *Report) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.Operations))
+	for name := range r.Operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ran for %s.\n\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "%-14s %9s %9s %9s %9s %9s %9s  %s\n", "operation", "requests", "req/s", "p50", "p90", "p99", "max", "errors")
+	for _, name := range names {
+		s := r.Operations[name]
+		throughput := "-"
+		if s.measured > 0 {
+			throughput = strconv.FormatFloat(r.Throughput(name), 'f', 1, 64)
+		}
+		fmt.Fprintf(&b, "%-14s %9d %9s %9s %9s %9s %9s  %s\n", name, s.Requests, throughput,
+			round(s.Percentile(50)), round(s.Percentile(90)), round(s.Percentile(99)), round(s.Percentile(100)),
+			formatErrors(s.Errors))
+	}
+	return b.String()
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+func formatErrors(errs map[string]int) string {
+	if len(errs) == 0 {
+		return "-"
+	}
+	codes := make([]string, 0, len(errs))
+	for code := range errs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%s=%d", code, errs[code])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,135 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the operations of the catalog like the real server
+// would, and rejects me without a token of a registered user.
+func fakeServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	users := map[string]bool{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string                     `json:"query"`
+			Variables map[string]json.RawMessage `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var input struct{ UserName string }
+		json.Unmarshal(req.Variables["input"], &input)
+
+		mu.Lock()
+		defer mu.Unlock()
+		var res string
+		switch {
+		case strings.Contains(req.Query, "registerUser"):
+			users[input.UserName] = true
+			res = `{"data": {"registerUser": "registered"}}`
+		case strings.Contains(req.Query, "loginUser"):
+			res = `{"data": {"loginUser": "token-` + input.UserName + `"}}`
+		case strings.Contains(req.Query, "books"):
+			res = `{"data": {"books": [{"_id": "b1", "title": "Go in Action"}]}}`
+		case strings.Contains(req.Query, "findReviews"):
+			res = `{"data": {"findReviews": []}}`
+		case strings.Contains(req.Query, "me"):
+			if !users[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-")] {
+				res = `{"data": {"me": null}, "errors": [{"message": "no", "extensions": {"code": "UNAUTHENTICATED"}}]}`
+			} else {
+				res = `{"data": {"me": {"_id": "u1"}}}`
+			}
+		case strings.Contains(req.Query, "addReview"):
+			w.WriteHeader(http.StatusServiceUnavailable)
+			res = `{"errors": [{"message": "overloaded"}]}`
+		}
+		w.Write([]byte(res))
+	}))
+}
+
+func TestRun(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	mix, err := ParseMix("findReviews=3, me, addReview=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(context.Background(), Config{
+		Target:      server.URL,
+		Mix:         mix,
+		Concurrency: 4,
+		Users:       3,
+		Duration:    200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := report.Operations["registerUser"]; s == nil || s.Requests != 3 || len(s.Errors) != 0 {
+		t.Errorf("registerUser stats = %+v, want 3 requests without errors", s)
+	}
+	if s := report.Operations["loginUser"]; s == nil || s.Requests != 3 {
+		t.Errorf("loginUser stats = %+v, want 3 requests", s)
+	}
+	for _, name := range []string{"findReviews", "me", "addReview"} {
+		s := report.Operations[name]
+		if s == nil || s.Requests == 0 {
+			t.Fatalf("no %s requests were sent", name)
+		}
+		if report.Throughput(name) <= 0 || s.Percentile(50) <= 0 || s.Percentile(99) < s.Percentile(50) {
+			t.Errorf("%s: throughput %f, p50 %s, p99 %s", name, report.Throughput(name), s.Percentile(50), s.Percentile(99))
+		}
+	}
+	if s := report.Operations["me"]; len(s.Errors) != 0 {
+		t.Errorf("me errors = %v; virtual users were not authenticated", s.Errors)
+	}
+	if s := report.Operations["addReview"]; s.Errors["HTTP_503"] != s.Requests {
+		t.Errorf("addReview errors = %v, want all %d as HTTP_503", s.Errors, s.Requests)
+	}
+	if out := report.String(); !strings.Contains(out, "HTTP_503=") || !strings.Contains(out, "findReviews") {
+		t.Errorf("report is missing operations or errors:\n%s", out)
+	}
+}
+
+func TestRunRate(t *testing.T) {
+	server := fakeServer(t)
+	defer server.Close()
+
+	mix, _ := ParseMix("books")
+	report, err := Run(context.Background(), Config{
+		Target:      server.URL,
+		Mix:         mix,
+		Concurrency: 8,
+		Rate:        50,
+		Duration:    300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first request reads the catalog; the run sends about 15 more.
+	if n := report.Operations["books"].Requests; n > 20 {
+		t.Errorf("%d requests at 50/s in 300ms", n)
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	for input, want := range map[string]string{
+		"nope=1":        "unknown operation",
+		"books=x":       "invalid weight",
+		"books=0":       "no operations",
+		"findBooks=-1,": "invalid weight",
+	} {
+		if _, err := ParseMix(input); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseMix(%q) = %v, want error containing %q", input, err, want)
+		}
+	}
+}
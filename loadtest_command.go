@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"grphqlserver/loadtest"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// loadtestCommand sends a weighted mix of operations to a running server
+// and prints throughput, latency percentiles and error codes per
+// operation. It doesn't read the server's configuration; to test a local
+// server without MongoDB, start it with STORE=memory and SEED_FIXTURES.
+//
+// Virtual users are registered on the target for every run, so don't
+// point it at a database whose users matter.
+func loadtestCommand(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := flags.String("url", "http://localhost:8080/graphql", "the server's GraphQL endpoint")
+	mix := flags.String("mix", "findReviews=8,books=1,me=1", "operations and their weights, from: books, findBooks, findReviews, me, addReview")
+	concurrency := flags.Int("concurrency", 10, "number of concurrent workers")
+	rate := flags.Float64("rate", 0, "maximum requests per second across workers; 0 for no limit")
+	duration := flags.Duration("duration", 30*time.Second, "how long to send requests")
+	users := flags.Int("users", 0, "virtual users to register for authenticated operations (default -concurrency)")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each request")
+	if !parseFlags(flags, args) {
+		return 2
+	}
+
+	operations, err := loadtest.ParseMix(*mix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-mix:", err)
+		return 2
+	}
+
+	// Interrupting the run still prints what was measured so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Sending %s to %s for %s with %d workers", *mix, *target, *duration, *concurrency)
+	if *rate > 0 {
+		fmt.Printf(" at up to %g requests/s", *rate)
+	}
+	fmt.Println(".")
+
+	report, err := loadtest.Run(ctx, loadtest.Config{
+		Target:      *target,
+		Mix:         operations,
+		Concurrency: *concurrency,
+		Rate:        *rate,
+		Duration:    *duration,
+		Users:       *users,
+		Timeout:     *timeout,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(report)
+	return 0
+}
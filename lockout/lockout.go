@@ -0,0 +1,133 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Entry is the failure history of a single key, such as an account or a
+// client IP.
+type Entry struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Store keeps failure counters. Entries are forgotten once ttl has passed
+// since their last failure.
+type Store interface {
+	Get(ctx context.Context, key string) (Entry, error)
+	Increment(ctx context.Context, key string, ttl time.Duration) (Entry, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy controls how quickly a key is slowed down and locked out.
+type Policy struct {
+	// FreeAttempts failures are allowed before any backoff applies.
+	FreeAttempts int
+	// BaseDelay is doubled for every failure past FreeAttempts, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// After LockoutThreshold failures the key is locked for LockoutDuration.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long failures are remembered.
+	Window time.Duration
+}
+
+var (
+	AccountPolicy = Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+		Window:           time.Hour,
+	}
+	IPPolicy = Policy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}
+)
+
+// ErrLocked is returned while a key has to wait before trying again.
+type ErrLocked struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrLocked) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+type Guard struct {
+	Store Store
+	now   func() time.Time
+}
+
+func NewGuard(store Store) *Guard {
+	return &Guard{Store: store, now: time.Now}
+}
+
+// Check returns an *ErrLocked if key may not attempt a login right now.
+func (g *Guard) Check(ctx context.Context, key string, policy Policy) error {
+	e, err := g.Store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	now := g.now()
+	until := e.LockedUntil
+	if next := e.LastFailure.Add(policy.backoff(e.Failures)); next.After(until) {
+		until = next
+	}
+	if until.After(now) {
+		return &ErrLocked{RetryAfter: until.Sub(now)}
+	}
+	return nil
+}
+
+// Fail records a failed attempt for key and locks it once the policy's
+// threshold is reached.
+func (g *Guard) Fail(ctx context.Context, key string, policy Policy) error {
+	e, err := g.Store.Increment(ctx, key, policy.Window)
+	if err != nil {
+		return err
+	}
+	if policy.LockoutThreshold > 0 && e.Failures >= policy.LockoutThreshold {
+		return g.Store.Lock(ctx, key, g.now().Add(policy.LockoutDuration))
+	}
+	return nil
+}
+
+func (g *Guard) Reset(ctx context.Context, key string) error {
+	return g.Store.Reset(ctx, key)
+}
+
+func (p Policy) backoff(failures int) time.Duration {
+	n := failures - p.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+func AccountKey(userName string) string {
+	return "account:" + userName
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,153 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testGuard returns a guard over a memory store that both read the clock
+// *now, so tests can move time forward.
+func testGuard(now *time.Time) *Guard {
+	clock := func() time.Time { return *now }
+	s := NewMemoryStore()
+	s.now = clock
+	g := NewGuard(s)
+	g.now = clock
+	return g
+}
+
+func retryAfter(err error) time.Duration {
+	var locked *ErrLocked
+	if errors.As(err, &locked) {
+		return locked.RetryAfter
+	}
+	return 0
+}
+
+func TestGuardBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		want     time.Duration
+	}{
+		{"account, no failures", AccountPolicy, 0, 0},
+		{"account, free attempts used", AccountPolicy, 3, 0},
+		{"account, first delay", AccountPolicy, 4, time.Second},
+		{"account, doubled", AccountPolicy, 6, 4 * time.Second},
+		{"account, last before lockout", AccountPolicy, 9, 32 * time.Second},
+		{"account, locked out", AccountPolicy, 10, 15 * time.Minute},
+		{"ip, free attempts used", IPPolicy, 20, 0},
+		{"ip, first delay", IPPolicy, 21, time.Second},
+		{"ip, capped", IPPolicy, 27, time.Minute},
+		{"ip, last before lockout", IPPolicy, 99, time.Minute},
+		{"ip, locked out", IPPolicy, 100, time.Hour},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		g := testGuard(&now)
+		for i := 0; i < tt.failures; i++ {
+			if err := g.Fail(ctx, "key", tt.policy); err != nil {
+				t.Fatalf("%s: Fail: %v", tt.name, err)
+			}
+		}
+		err := g.Check(ctx, "key", tt.policy)
+		if got := retryAfter(err); got != tt.want || (tt.want == 0) != (err == nil) {
+			t.Errorf("%s: Check = %v, want retry after %s", tt.name, err, tt.want)
+		}
+
+		// Once the wait is over the key may try again.
+		now = now.Add(tt.want)
+		if err := g.Check(ctx, "key", tt.policy); err != nil {
+			t.Errorf("%s: Check after waiting %s = %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestGuardReset(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"account", AccountPolicy},
+		{"ip", IPPolicy},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		g := testGuard(&now)
+		for i := 0; i < tt.policy.LockoutThreshold; i++ {
+			if err := g.Fail(ctx, "key", tt.policy); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := g.Fail(ctx, "other", tt.policy); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Check(ctx, "key", tt.policy); err == nil {
+			t.Fatalf("%s: not locked after %d failures", tt.name, tt.policy.LockoutThreshold)
+		}
+
+		// A successful login resets the key, and only that key.
+		if err := g.Reset(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Check(ctx, "key", tt.policy); err != nil {
+			t.Errorf("%s: Check after Reset = %v", tt.name, err)
+		}
+		if e, _ := g.Store.Get(ctx, "other"); e.Failures != 1 {
+			t.Errorf("%s: other key has %d failures after Reset, want 1", tt.name, e.Failures)
+		}
+
+		// The count starts over.
+		if err := g.Fail(ctx, "key", tt.policy); err != nil {
+			t.Fatal(err)
+		}
+		if e, _ := g.Store.Get(ctx, "key"); e.Failures != 1 {
+			t.Errorf("%s: %d failures after Reset and Fail, want 1", tt.name, e.Failures)
+		}
+	}
+}
+
+func TestGuardExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		wait     time.Duration
+		want     int
+	}{
+		{"account, within window", AccountPolicy, 5, 59 * time.Minute, 5},
+		{"account, window passed", AccountPolicy, 5, time.Hour + time.Second, 0},
+		{"account, lockout passed", AccountPolicy, 10, 15*time.Minute + time.Second, 10},
+		{"account, window passed after lockout", AccountPolicy, 10, time.Hour + time.Second, 0},
+		{"ip, within window", IPPolicy, 25, 59 * time.Minute, 25},
+		{"ip, window passed", IPPolicy, 25, time.Hour + time.Second, 0},
+		{"ip, window passed after lockout", IPPolicy, 100, time.Hour + time.Second, 0},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		g := testGuard(&now)
+		for i := 0; i < tt.failures; i++ {
+			if err := g.Fail(ctx, "key", tt.policy); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		now = now.Add(tt.wait)
+		e, err := g.Store.Get(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Failures != tt.want {
+			t.Errorf("%s: %d failures remembered, want %d", tt.name, e.Failures, tt.want)
+		}
+		if err := g.Check(ctx, "key", tt.policy); err != nil {
+			t.Errorf("%s: Check = %v", tt.name, err)
+		}
+	}
+}
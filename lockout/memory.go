@@ -0,0 +1,84 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	Entry
+	expiresAt time.Time
+}
+
+// MemoryStore keeps counters in process memory. They are lost on restart
+// and not shared between instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*memoryEntry{}, now: time.Now}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.live(key); e != nil {
+		return e.Entry, nil
+	}
+	return Entry{}, nil
+}
+
+func (s *MemoryStore) Increment(_ context.Context, key string, ttl time.Duration) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	now := s.now()
+	e.Failures++
+	e.LastFailure = now
+	if exp := now.Add(ttl); exp.After(e.expiresAt) {
+		e.expiresAt = exp
+	}
+	return e.Entry, nil
+}
+
+func (s *MemoryStore) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.live(key)
+	if e == nil {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	e.LockedUntil = until
+	if until.After(e.expiresAt) {
+		e.expiresAt = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) live(key string) *memoryEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if s.now().After(e.expiresAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
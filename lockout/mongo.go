@@ -0,0 +1,86 @@
+package lockout
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps counters in a collection so they are shared by every
+// instance. A TTL index on expiresAt removes stale entries.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoStore(ctx context.Context, collection *mongo.Collection) (*MongoStore, error) {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MongoStore{collection: collection}, nil
+}
+
+type mongoEntry struct {
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"lastFailure"`
+	LockedUntil time.Time `bson:"lockedUntil"`
+}
+
+func (s *MongoStore) Get(ctx context.Context, key string) (Entry, error) {
+	var e mongoEntry
+	err := s.collection.FindOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return Entry{}, nil
+	} else if err != nil {
+		return Entry{}, err
+	}
+	return Entry(e), nil
+}
+
+func (s *MongoStore) Increment(ctx context.Context, key string, ttl time.Duration) (Entry, error) {
+	now := time.Now()
+
+	// Start over if the previous entry has expired but not been removed yet.
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}})
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var e mongoEntry
+	err = s.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"lastFailure": now},
+			"$max": bson.M{"expiresAt": now.Add(ttl)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&e)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry(e), nil
+}
+
+func (s *MongoStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$set": bson.M{"lockedUntil": until},
+			"$max": bson.M{"expiresAt": until},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *MongoStore) Reset(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,82 @@
+// Package logging sets up structured logging with log/slog and carries a
+// request-scoped logger through the context.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup installs the default logger. LOG_FORMAT selects "json" (the
+// default) or "text", LOG_LEVEL one of debug, info, warn or error.
+func Setup() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+type loggerKey struct{}
+
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the request's logger, or the default logger outside
+// of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+var sensitive = []string{"password", "token", "secret", "apikey", "api_key", "authorization"}
+
+// Redact returns a copy of GraphQL variables or arguments with the values
+// of sensitive keys, such as passwords and tokens, replaced.
+func Redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			if isSensitive(k) {
+				out[k] = "[REDACTED]"
+			} else {
+				out[k] = Redact(val)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = Redact(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitive {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
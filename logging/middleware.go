@@ -0,0 +1,178 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"grphqlserver/apperr"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/visitor"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// operation is filled in by ResultCallback while the handler runs and
+// logged by Middleware once the response is written.
+type operation struct {
+	name       string
+	kind       string
+	variables  map[string]interface{}
+	errorCodes []string
+}
+
+type operationKey struct{}
+
+type requestIDKey struct{}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware assigns every request an ID, taken from X-Request-ID when the
+// client sends one, stores a logger carrying it in the context and logs one
+// line per request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		op := &operation{}
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, operationKey{}, op)
+		ctx = WithLogger(ctx, logger)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		}
+		if op.kind != "" {
+			attrs = append(attrs,
+				"operation_name", op.name,
+				"operation_type", op.kind,
+				"variables", op.variables)
+		}
+		level := slog.LevelInfo
+		if len(op.errorCodes) > 0 {
+			attrs = append(attrs, "error_codes", op.errorCodes)
+			level = slog.LevelWarn
+		}
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		logger.Log(ctx, level, "request", attrs...)
+	})
+}
+
+// ResultCallback is the GraphQL handler's ResultCallbackFn. It records the
+// operation and the error codes of its result for the request log line.
+func ResultCallback(ctx context.Context, params *graphql.Params, result *graphql.Result, _ []byte) {
+	op, ok := ctx.Value(operationKey{}).(*operation)
+	if !ok {
+		return
+	}
+
+	op.name = params.OperationName
+	op.kind = "unknown"
+	op.variables = map[string]interface{}{}
+	doc, err := parser.Parse(parser.ParseParams{Source: params.RequestString})
+	if err == nil {
+		op.kind = operationType(doc, params.OperationName)
+		op.variables = redactVariables(doc, params.VariableValues)
+	}
+	for _, e := range result.Errors {
+		code := "UNKNOWN"
+		if c, ok := e.Extensions["code"]; ok {
+			code = string(toCode(c))
+		}
+		op.errorCodes = append(op.errorCodes, code)
+	}
+}
+
+func toCode(c interface{}) apperr.Code {
+	switch c := c.(type) {
+	case apperr.Code:
+		return c
+	case string:
+		return apperr.Code(c)
+	}
+	return "UNKNOWN"
+}
+
+// operationType returns "query", "mutation" or "subscription" for the
+// operation that will run.
+func operationType(doc *ast.Document, name string) string {
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" || (op.Name != nil && op.Name.Value == name) {
+			return op.Operation
+		}
+	}
+	return "unknown"
+}
+
+// redactVariables redacts variables with sensitive names as well as those
+// passed to sensitive arguments, such as $p in loginUser(password: $p).
+func redactVariables(doc *ast.Document, vars map[string]interface{}) map[string]interface{} {
+	hidden := map[string]bool{}
+	visitor.Visit(doc, &visitor.VisitorOptions{
+		Enter: func(p visitor.VisitFuncParams) (string, interface{}) {
+			var name *ast.Name
+			var value ast.Value
+			switch node := p.Node.(type) {
+			case *ast.Argument:
+				name, value = node.Name, node.Value
+			case *ast.ObjectField:
+				name, value = node.Name, node.Value
+			}
+			if v, ok := value.(*ast.Variable); ok && name != nil && isSensitive(name.Value) {
+				hidden[v.Name.Value] = true
+			}
+			return visitor.ActionNoChange, nil
+		},
+	}, nil)
+
+	out, _ := Redact(vars).(map[string]interface{})
+	for k := range out {
+		if hidden[k] {
+			out[k] = "[REDACTED]"
+		}
+	}
+	return out
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outgoing email. Implementations must be safe for
+// concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv builds a mailer from the MAILER environment variable:
+// "smtp", "file" (the default) or "memory".
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+
+	switch driver := os.Getenv("MAILER"); driver {
+	case "smtp":
+		return &SMTPMailer{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "", "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "maildrop"
+		}
+		return &FileMailer{Dir: dir, From: from}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", driver)
+	}
+}
@@ -1,31 +1,161 @@
 package main
 
 import (
-	"grphqlserver/middleware"
-	"log"
-	"net/http"
-
-	"github.com/graphql-go/graphql"
-	"github.com/graphql-go/handler"
+	"context"
+	"errors"
+	"fmt"
+	"grphqlserver/auth"
+	"grphqlserver/cache"
+	"grphqlserver/lockout"
+	"grphqlserver/logging"
+	"grphqlserver/mailer"
+	"grphqlserver/resolvers"
+	"os"
+	"strconv"
+	"time"
 )
 
+const usage = `usage: grphqlserver [command] [arguments]
+
+commands:
+  serve                  run the GraphQL server (the default)
+  migrate [-status]      apply pending database migrations
+  user create            create a user, e.g. with -role admin
+  user reset-password    set a user's password and revoke their sessions
+  user disable           block a user and revoke their sessions and API keys
+  user enable            let a disabled user log in again
+  seed [-file] [-reset]  load fixtures into an empty database
+  reindex [-drop]        create missing indexes, or rebuild all of them
+  check                  check configuration and dependencies
+  schema print|diff      print the schema or compare two schema files
+  loadtest               send a mix of operations to a server and report
+                         throughput, latency and errors
+
+Every command reads the same environment variables as the server.`
+
+// commands run a subcommand with its arguments and return the exit code.
+var commands = map[string]func(args []string) int{
+	"serve":    serveCommand,
+	"migrate":  migrateCommand,
+	"user":     userCommand,
+	"seed":     seedCommand,
+	"reindex":  reindexCommand,
+	"check":    checkCommand,
+	"schema":   schemaCommand,
+	"loadtest": loadtestCommand,
+}
+
 func main() {
-	schema, err := graphql.NewSchema(defineSchema())
-	if err != nil {
-		log.Panic("Error in creating graphQL schema", err)
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Println(usage)
+		return
 	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", name, usage)
+		os.Exit(2)
+	}
+	os.Exit(command(args))
+}
 
-	h := handler.New(&handler.Config{
-		Schema:     &schema,
-		Pretty:     true,
-		GraphiQL:   false,
-		Playground: true,
-	})
+// configure sets up logging, the store and the account settings from the
+// environment. Every command that touches the database calls it first.
+func configure() error {
+	logging.Setup()
+
+	switch backend := os.Getenv("STORE"); backend {
+	case "", "mongo":
+		mongoURI := os.Getenv("MONGO_URI")
+		if mongoURI == "" {
+			mongoURI = "mongodb://mongo:27017"
+		}
+		if err := resolvers.Connect(context.Background(), mongoURI); err != nil {
+			return fmt.Errorf("configuring the mongodb client: %w", err)
+		}
+	case "memory":
+		// Nothing survives a restart; this is for local load tests and demos.
+		resolvers.UseStore(resolvers.NewMemoryStore())
+	default:
+		return fmt.Errorf("unknown store %q", backend)
+	}
 
-	http.Handle("/graphql", middleware.InjectHeadersMiddleware(h))
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "", "memory":
+		resolvers.EnableReadCache(cache.NewLRU(envInt("CACHE_MAX_ENTRIES", 1000)), envDuration("CACHE_TTL", time.Minute))
+	case "none":
+	default:
+		return fmt.Errorf("unknown cache backend %q", backend)
+	}
 
-	err = http.ListenAndServe(":8080", nil)
+	m, err := mailer.FromEnv()
 	if err != nil {
-		log.Panic("Error when starting the http server", err)
+		return fmt.Errorf("configuring the mailer: %w", err)
+	}
+	resolvers.Mailer = m
+	resolvers.RequireVerifiedEmail, _ = strconv.ParseBool(os.Getenv("REQUIRE_VERIFIED_EMAIL"))
+
+	switch policy := os.Getenv("ACCOUNT_DELETION_REVIEWS"); policy {
+	case "":
+	case resolvers.ReviewsAnonymize, resolvers.ReviewsDelete:
+		resolvers.DeletedUserReviews = policy
+	default:
+		return fmt.Errorf("unknown account deletion review policy %q", policy)
+	}
+
+	if alg := os.Getenv("PASSWORD_HASH"); alg != "" {
+		auth.Hashing.Algorithm = alg
+	}
+	if cost, err := strconv.Atoi(os.Getenv("BCRYPT_COST")); err == nil {
+		auth.Hashing.BcryptCost = cost
+	}
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		auth.Policy.MinLength = n
+	}
+	if _, err := auth.HashPassword("startup check"); err != nil {
+		return fmt.Errorf("password hashing configuration: %w", err)
+	}
+
+	switch store := os.Getenv("LOGIN_ATTEMPT_STORE"); store {
+	case "", "memory":
+	case "mongo":
+		if os.Getenv("STORE") == "memory" {
+			return errors.New("LOGIN_ATTEMPT_STORE=mongo can't be used with STORE=memory; use LOGIN_ATTEMPT_STORE=memory")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		s, err := lockout.NewMongoStore(ctx, resolvers.LoginAttemptsCollection())
+		cancel()
+		if err != nil {
+			return fmt.Errorf("creating the login attempt store: %w", err)
+		}
+		resolvers.LoginGuard = lockout.NewGuard(s)
+	default:
+		return fmt.Errorf("unknown login attempt store %q", store)
+	}
+
+	return nil
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return d
+	}
+	return def
+}
+
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return n
+	}
+	return def
+}
+
+func envBool(name string, def bool) bool {
+	if b, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+		return b
 	}
+	return def
 }
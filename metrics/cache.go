@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_hits_total",
+		Help: "Reads served from the query cache, by collection.",
+	}, []string{"cache"})
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_misses_total",
+		Help: "Reads the query cache had to pass to the database, by collection.",
+	}, []string{"cache"})
+	cacheInvalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_invalidations_total",
+		Help: "Writes that dropped a collection's cached reads, by collection.",
+	}, []string{"cache"})
+)
+
+// CacheLookup counts a hit or a miss in the named cache.
+func CacheLookup(cache string, hit bool) {
+	if hit {
+		cacheHits.WithLabelValues(cache).Inc()
+	} else {
+		cacheMisses.WithLabelValues(cache).Inc()
+	}
+}
+
+// CacheInvalidated counts an invalidation of the named cache.
+func CacheInvalidated(cache string) {
+	cacheInvalidations.WithLabelValues(cache).Inc()
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type operation struct {
+	name   string
+	kind   string
+	failed bool
+}
+
+type operationKey struct{}
+
+// Middleware tracks in-flight requests and, for GraphQL requests, the
+// operation's count and latency.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		op := &operation{}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), operationKey{}, op)))
+
+		if op.kind == "" {
+			return
+		}
+		status := "ok"
+		if op.failed {
+			status = "error"
+		}
+		operations.WithLabelValues(op.name, op.kind, status).Inc()
+		operationDuration.WithLabelValues(op.name, op.kind).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ResultCallback is called by the GraphQL handler with every result.
+func ResultCallback(ctx context.Context, params *graphql.Params, result *graphql.Result, _ []byte) {
+	for _, e := range result.Errors {
+		code := "UNKNOWN"
+		if c, ok := e.Extensions["code"]; ok {
+			code = fmt.Sprint(c)
+		}
+		errorsByCode.WithLabelValues(code).Inc()
+	}
+
+	op, ok := ctx.Value(operationKey{}).(*operation)
+	if !ok {
+		return
+	}
+	op.name = operationLabel(params.OperationName)
+	op.kind = operationType(params.RequestString, params.OperationName)
+	op.failed = len(result.Errors) > 0
+}
+
+func operationType(query, name string) string {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return "invalid"
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if ok && (name == "" || (op.Name != nil && op.Name.Value == name)) {
+			return op.Operation
+		}
+	}
+	return "invalid"
+}
@@ -0,0 +1,86 @@
+// Package metrics exposes Prometheus metrics for HTTP requests, GraphQL
+// operations and resolvers, and MongoDB commands.
+package metrics
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	inFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+	operations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "graphql_operations_total",
+		Help: "GraphQL operations by operation name, type and outcome.",
+	}, []string{"operation", "type", "status"})
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "graphql_operation_duration_seconds",
+		Help:    "Time taken to serve a GraphQL request, by operation name.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "type"})
+	resolverDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "graphql_resolver_duration_seconds",
+		Help:    "Time spent in resolvers, by field.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"field", "status"})
+	errorsByCode = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "graphql_errors_total",
+		Help: "GraphQL errors returned to clients, by extensions.code.",
+	}, []string{"code"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+var (
+	operationsMu   sync.RWMutex
+	operationNames = map[string]bool{}
+)
+
+// TrackOperations sets the operation names that get their own label
+// value. Other named operations are counted as "other": the names come
+// from configuration rather than from requests, so clients can't grow the
+// number of series.
+func TrackOperations(names []string) {
+	tracked := make(map[string]bool, len(names))
+	for _, name := range names {
+		tracked[name] = true
+	}
+	operationsMu.Lock()
+	defer operationsMu.Unlock()
+	operationNames = tracked
+}
+
+// OperationsFromEnv reads METRICS_OPERATIONS, a comma-separated list of
+// the operation names to track.
+func OperationsFromEnv() []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("METRICS_OPERATIONS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func operationLabel(name string) string {
+	if name == "" {
+		return "anonymous"
+	}
+	operationsMu.RLock()
+	defer operationsMu.RUnlock()
+	if operationNames[name] {
+		return name
+	}
+	return "other"
+}
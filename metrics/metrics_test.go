@@ -0,0 +1,22 @@
+package metrics
+
+import "testing"
+
+func TestOperationLabel(t *testing.T) {
+	t.Setenv("METRICS_OPERATIONS", "Books, FindReviews,,")
+	TrackOperations(OperationsFromEnv())
+	defer TrackOperations(nil)
+
+	tests := map[string]string{
+		"Books":       "Books",
+		"FindReviews": "FindReviews",
+		"":            "anonymous",
+		"books":       "other",
+		"Attacker123": "other",
+	}
+	for name, want := range tests {
+		if got := operationLabel(name); got != want {
+			t.Errorf("operationLabel(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
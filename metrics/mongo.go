@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	mongoCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongo_command_duration_seconds",
+		Help:    "MongoDB command latency, by command name and outcome.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"command", "status"})
+	mongoPoolConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongo_pool_connections",
+		Help: "MongoDB connections, by state: open or in_use.",
+	}, []string{"state"})
+	mongoPoolCheckoutFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongo_pool_checkout_failures_total",
+		Help: "Failed attempts to check a connection out of the pool, by reason.",
+	}, []string{"reason"})
+)
+
+// CommandMonitor records the latency of every command sent to MongoDB.
+func CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			mongoCommandDuration.WithLabelValues(e.CommandName, "ok").Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			mongoCommandDuration.WithLabelValues(e.CommandName, "error").Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// PoolMonitor tracks open and checked-out connections.
+func PoolMonitor() *event.PoolMonitor {
+	open := mongoPoolConnections.WithLabelValues("open")
+	inUse := mongoPoolConnections.WithLabelValues("in_use")
+
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				open.Inc()
+			case event.ConnectionClosed:
+				open.Dec()
+			case event.GetSucceeded:
+				inUse.Inc()
+			case event.ConnectionReturned:
+				inUse.Dec()
+			case event.GetFailed:
+				mongoPoolCheckoutFailures.WithLabelValues(e.Reason).Inc()
+			}
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// Extension is a graphql-go schema extension that times every field with
+// a resolver of its own. Fields resolved by reading the parent object are
+// skipped, as they are cheap and numerous.
+type Extension struct{}
+
+var _ graphql.Extension = Extension{}
+
+func (Extension) Init(ctx context.Context, _ *graphql.Params) context.Context {
+	return ctx
+}
+
+func (Extension) Name() string {
+	return "metrics"
+}
+
+func (Extension) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(error) {}
+}
+
+func (Extension) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func([]gqlerrors.FormattedError) {}
+}
+
+func (Extension) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	return ctx, func(*graphql.Result) {}
+}
+
+func (Extension) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	if !hasResolver(info) {
+		return ctx, func(interface{}, error) {}
+	}
+
+	field := info.ParentType.Name() + "." + info.FieldName
+	start := time.Now()
+	return ctx, func(_ interface{}, err error) {
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		resolverDuration.WithLabelValues(field, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (Extension) HasResult() bool {
+	return false
+}
+
+func (Extension) GetResult(context.Context) interface{} {
+	return nil
+}
+
+func hasResolver(info *graphql.ResolveInfo) bool {
+	obj, ok := info.ParentType.(*graphql.Object)
+	if !ok {
+		return false
+	}
+	def, ok := obj.Fields()[info.FieldName]
+	return ok && def.Resolve != nil
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"grphqlserver/apperr"
+	"grphqlserver/auth"
+	"grphqlserver/policy"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rule decides whether the caller may resolve a field. id is nil for
+// anonymous requests.
+type Rule func(id *auth.Identity, p graphql.ResolveParams) bool
+
+// Authenticated allows any caller with a valid token.
+func Authenticated(id *auth.Identity, _ graphql.ResolveParams) bool {
+	return id != nil
+}
+
+// Can applies the policy engine to a field. ownerField names the parent
+// object's field holding the owner's ID, or is empty for top-level fields.
+func Can(action policy.Action, kind policy.Kind, ownerField string) Rule {
+	return func(id *auth.Identity, p graphql.ResolveParams) bool {
+		resource := policy.Resource{Kind: kind}
+		if ownerField != "" {
+			resource.OwnerID = sourceID(p, ownerField)
+		}
+		return policy.Can(id, action, resource)
+	}
+}
+
+// sourceID returns a user ID stored in a field of the parent object as hex.
+func sourceID(p graphql.ResolveParams, field string) string {
+	source, ok := p.Source.(bson.M)
+	if !ok {
+		source, ok = p.Source.(map[string]interface{})
+	}
+	if !ok {
+		return ""
+	}
+	switch v := source[field].(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case string:
+		return v
+	}
+	return ""
+}
+
+// Auth guards a field with a rule, like an @auth(requires: ...) directive.
+// A nil next resolves the field from its parent like graphql-go does by
+// default.
+func Auth(requires Rule, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	if next == nil {
+		next = graphql.DefaultResolveFn
+	}
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		id, err := CurrentIdentity(p.Context)
+		if err != nil && err != errMissingToken {
+			return nil, err
+		}
+
+		if !requires(id, p) {
+			if id == nil {
+				return nil, apperr.NewUnauthenticated("unauthenticated: login required")
+			}
+			return nil, apperr.NewForbidden("forbidden: not allowed to access " + p.Info.FieldName)
+		}
+
+		if id != nil {
+			p.Context = withIdentity(p.Context, id)
+		}
+		return next(p)
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call the API.
+type CORSConfig struct {
+	// AllowedOrigins lists exact origins such as "https://app.example.com",
+	// or "*" for any origin. An empty list disables CORS.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// AllowCredentials lets listed origins send cookies and read the
+	// response. It never applies to origins allowed by "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// CORSFromEnv reads CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS and
+// CORS_ALLOWED_HEADERS as comma-separated lists, CORS_ALLOW_CREDENTIALS and
+// CORS_MAX_AGE. Credentials can't be allowed together with "*", which
+// would let every site make credentialed requests.
+func CORSFromEnv() (CORSConfig, error) {
+	cfg := CORSConfig{
+		AllowedOrigins: splitList(os.Getenv("CORS_ALLOWED_ORIGINS")),
+		AllowedMethods: splitList(os.Getenv("CORS_ALLOWED_METHODS")),
+		AllowedHeaders: splitList(os.Getenv("CORS_ALLOWED_HEADERS")),
+		MaxAge:         10 * time.Minute,
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{http.MethodGet, http.MethodPost}
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = []string{"Content-Type", "Authorization", "X-API-Key", "X-Request-ID"}
+	}
+	cfg.AllowCredentials, _ = strconv.ParseBool(os.Getenv("CORS_ALLOW_CREDENTIALS"))
+	if d, err := time.ParseDuration(os.Getenv("CORS_MAX_AGE")); err == nil {
+		cfg.MaxAge = d
+	}
+	if cfg.AllowCredentials && cfg.wildcard() {
+		return cfg, errors.New(`CORS_ALLOW_CREDENTIALS can't be combined with CORS_ALLOWED_ORIGINS="*"; list the origins instead`)
+	}
+	return cfg, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (c CORSConfig) wildcard() bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin reports whether origin may call the API, and whether it is
+// listed by name rather than allowed by "*".
+func (c CORSConfig) allowOrigin(origin string) (allowed, listed bool) {
+	for _, o := range c.AllowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true, true
+		}
+	}
+	return c.wildcard(), false
+}
+
+// CORS answers preflight requests and adds the Access-Control headers to
+// requests from allowed origins. Requests from other origins are served
+// without them, so the browser keeps the response from the page.
+func CORS(cfg CORSConfig, next http.Handler) http.Handler {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		allowed, listed := cfg.allowOrigin(origin)
+		if origin == "" || !allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only origins listed by name may send credentials. Others get the
+		// wildcard itself, which browsers never combine with credentials.
+		if listed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	listed := CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	wildcard := listed
+	wildcard.AllowedOrigins = []string{"*", "https://app.example.com"}
+
+	tests := []struct {
+		name        string
+		cfg         CORSConfig
+		method      string
+		origin      string
+		preflight   bool
+		wantOrigin  string
+		wantCreds   string
+		wantMethods string
+		wantStatus  int
+	}{
+		{name: "allowed origin", cfg: listed, method: http.MethodPost, origin: "https://app.example.com",
+			wantOrigin: "https://app.example.com", wantCreds: "true", wantStatus: http.StatusOK},
+		{name: "origin case differs", cfg: listed, method: http.MethodPost, origin: "https://APP.example.com",
+			wantOrigin: "https://APP.example.com", wantCreds: "true", wantStatus: http.StatusOK},
+		{name: "denied origin", cfg: listed, method: http.MethodPost, origin: "https://evil.example.com",
+			wantStatus: http.StatusOK},
+		{name: "no origin", cfg: listed, method: http.MethodPost, wantStatus: http.StatusOK},
+		{name: "preflight", cfg: listed, method: http.MethodOptions, origin: "https://app.example.com", preflight: true,
+			wantOrigin: "https://app.example.com", wantCreds: "true", wantMethods: "GET, POST", wantStatus: http.StatusNoContent},
+		{name: "preflight from denied origin", cfg: listed, method: http.MethodOptions, origin: "https://evil.example.com", preflight: true,
+			wantStatus: http.StatusOK},
+		{name: "wildcard without credentials", cfg: wildcard, method: http.MethodPost, origin: "https://evil.example.com",
+			wantOrigin: "*", wantStatus: http.StatusOK},
+		{name: "wildcard preflight", cfg: wildcard, method: http.MethodOptions, origin: "https://evil.example.com", preflight: true,
+			wantOrigin: "*", wantMethods: "GET, POST", wantStatus: http.StatusNoContent},
+		{name: "listed origin keeps credentials next to wildcard", cfg: wildcard, method: http.MethodPost, origin: "https://app.example.com",
+			wantOrigin: "https://app.example.com", wantCreds: "true", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := CORS(tt.cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(tt.method, "/graphql", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if got := w.Header().Values("Vary"); len(got) == 0 || got[0] != "Origin" {
+				t.Errorf("Vary = %v, want Origin first", got)
+			}
+		})
+	}
+}
+
+func TestCORSFromEnv(t *testing.T) {
+	tests := []struct {
+		origins, credentials string
+		wantErr              bool
+	}{
+		{"https://app.example.com", "true", false},
+		{"*", "false", false},
+		{"*", "", false},
+		{"https://app.example.com, *", "true", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("CORS_ALLOWED_ORIGINS", tt.origins)
+		t.Setenv("CORS_ALLOW_CREDENTIALS", tt.credentials)
+		cfg, err := CORSFromEnv()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("origins %q, credentials %q: error %v, want error %v", tt.origins, tt.credentials, err, tt.wantErr)
+		}
+		if err == nil && len(cfg.AllowedMethods) == 0 {
+			t.Errorf("origins %q: no default methods", tt.origins)
+		}
+	}
+}
@@ -2,33 +2,86 @@ package middleware
 
 import (
 	"context"
-	"errors"
+	"grphqlserver/apperr"
 	"grphqlserver/auth"
+	"grphqlserver/resolvers"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/graphql-go/graphql"
 )
 
-func AuthMiddleware(next graphql.FieldResolveFn) graphql.FieldResolveFn {
-	return func(p graphql.ResolveParams) (interface{}, error) {
+var errMissingToken = apperr.NewUnauthenticated("missing token")
 
-		authHeader, ok := p.Context.Value("Authorization").(string)
-		if !ok || authHeader == "" {
-			return nil, errors.New("missing token")
-		}
+// requestIdentity authenticates the request's Authorization header the
+// first time it is needed and remembers the outcome, so field-level checks
+// don't repeat the session lookup.
+type requestIdentity struct {
+	once     sync.Once
+	header   string
+	apiKey   string
+	identity *auth.Identity
+	err      error
+}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			return nil, errors.New("invalid token format")
-		}
+func (r *requestIdentity) get(ctx context.Context) (*auth.Identity, error) {
+	r.once.Do(func() {
+		r.identity, r.err = authenticate(ctx, r.header, r.apiKey)
+	})
+	return r.identity, r.err
+}
+
+// authenticate accepts either a bearer token or an API key.
+func authenticate(ctx context.Context, authHeader, apiKey string) (*auth.Identity, error) {
+	if apiKey != "" {
+		return resolvers.LoadAPIKey(ctx, apiKey)
+	}
+	if authHeader == "" {
+		return nil, errMissingToken
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		return nil, apperr.NewUnauthenticated("invalid token format")
+	}
+
+	userID, version, err := auth.ValidateToken(tokenString)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Unauthenticated, err)
+	}
+
+	return resolvers.LoadSession(ctx, userID, version)
+}
+
+// CurrentIdentity returns the authenticated caller of the request, or an
+// error if the request carries no valid token.
+func CurrentIdentity(ctx context.Context) (*auth.Identity, error) {
+	if r, ok := ctx.Value("Identity").(*requestIdentity); ok {
+		return r.get(ctx)
+	}
+	authHeader, _ := ctx.Value("Authorization").(string)
+	apiKey, _ := ctx.Value("APIKey").(string)
+	return authenticate(ctx, authHeader, apiKey)
+}
 
-		userID, err := auth.ValidateToken(tokenString)
+func withIdentity(ctx context.Context, id *auth.Identity) context.Context {
+	ctx = context.WithValue(ctx, "userID", id.UserID)
+	ctx = context.WithValue(ctx, "role", id.Role)
+	if id.Scopes != nil {
+		ctx = context.WithValue(ctx, "scopes", id.Scopes)
+	}
+	return ctx
+}
+
+func AuthMiddleware(next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		id, err := CurrentIdentity(p.Context)
 		if err != nil {
 			return nil, err
 		}
 
-		p.Context = context.WithValue(p.Context, "userID", userID)
+		p.Context = withIdentity(p.Context, id)
 
 		return next(p)
 	}
@@ -37,7 +90,25 @@ func AuthMiddleware(next graphql.FieldResolveFn) graphql.FieldResolveFn {
 func InjectHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
+		apiKey := r.Header.Get("X-API-Key")
 		ctx := context.WithValue(r.Context(), "Authorization", authHeader)
+		ctx = context.WithValue(ctx, "APIKey", apiKey)
+		ctx = context.WithValue(ctx, "Identity", &requestIdentity{header: authHeader, apiKey: apiKey})
+		if ip := ClientIP(r); ip != "" {
+			ctx = context.WithValue(ctx, "ClientIP", ip)
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// LimitBody rejects request bodies larger than n bytes.
+func LimitBody(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies and load balancers in front of
+// the server. Their X-Forwarded-For and X-Real-IP headers name the client;
+// those headers are ignored on requests from anywhere else.
+var TrustedProxies []*net.IPNet
+
+// TrustedProxiesFromEnv reads TRUSTED_PROXIES, a comma-separated list of
+// IP addresses and CIDR ranges such as "10.0.0.0/8,192.168.1.7".
+func TrustedProxiesFromEnv() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range splitList(os.Getenv("TRUSTED_PROXIES")) {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", v)
+			}
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, n := range TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent r. Behind trusted
+// proxies that is the last address in X-Forwarded-For that isn't one of
+// them, or else X-Real-IP; addresses further left were written by the
+// client and can't be believed.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !trustedProxy(peer) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !trustedProxy(ip) {
+			return client
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
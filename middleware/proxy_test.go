@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.7")
+	proxies, err := TrustedProxiesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := TrustedProxies
+	TrustedProxies = proxies
+	defer func() { TrustedProxies = saved }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"untrusted peer can't forward", "203.0.113.5:4000", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"}, "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hops are skipped", "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, 192.168.1.7, 10.9.9.9"}, "198.51.100.1"},
+		{"garbage stops the walk", "10.1.2.3:4000", map[string]string{"X-Forwarded-For": "198.51.100.1, nonsense, 10.9.9.9"}, "10.9.9.9"},
+		{"real IP", "192.168.1.7:4000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"trusted proxy without headers", "10.1.2.3:4000", nil, "10.1.2.3"},
+		{"IPv6 peer", "[2001:db8::1]:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/graphql", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := ClientIP(r); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"":                      true,
+		"10.0.0.0/8":            true,
+		"192.168.1.7, ::1":      true,
+		"10.0.0.0/33":           false,
+		"proxy.internal":        false,
+		"10.0.0.1,not-an-ip/24": false,
+	} {
+		t.Setenv("TRUSTED_PROXIES", value)
+		if _, err := TrustedProxiesFromEnv(); (err == nil) != ok {
+			t.Errorf("TRUSTED_PROXIES=%q: error %v, want ok=%v", value, err, ok)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"grphqlserver/apperr"
+	"grphqlserver/logging"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// Recover turns a panic while serving a request into a 500 response with
+// a GraphQL error body, instead of a dropped connection.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logging.FromContext(r.Context()).Error("panic serving request",
+				"panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []gqlerrors.FormattedError{apperr.FormatError(apperr.NewInternal(fmt.Errorf("panic: %v", v)))},
+			})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RecoverResolvers wraps every field that has its own resolver so that a
+// panic becomes an INTERNAL error on that field, logged with its stack.
+// graphql-go recovers panics itself but drops the stack, and it crashes the
+// process on panics whose value isn't an error.
+func RecoverResolvers(schema *graphql.Schema) {
+	for _, t := range schema.TypeMap() {
+		obj, ok := t.(*graphql.Object)
+		if !ok {
+			continue
+		}
+		for _, def := range obj.Fields() {
+			if def.Resolve != nil {
+				def.Resolve = recoverResolver(def.Resolve)
+			}
+		}
+	}
+}
+
+func recoverResolver(next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (result interface{}, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				logging.FromContext(p.Context).Error("panic in resolver",
+					"field", p.Info.ParentType.Name()+"."+p.Info.FieldName,
+					"panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+				result, err = nil, apperr.NewInternal(fmt.Errorf("panic: %v", v))
+			}
+		}()
+		return next(p)
+	}
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grphqlserver/apperr"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// Timeouts bounds how long GraphQL work may run. Operations are matched by
+// operation name and fields by "Type.field"; anything not listed gets the
+// default. A zero duration means no limit.
+type Timeouts struct {
+	Operation  time.Duration
+	Operations map[string]time.Duration
+	Field      time.Duration
+	Fields     map[string]time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Operation: 25 * time.Second,
+	Field:     10 * time.Second,
+}
+
+// TimeoutsFromEnv reads GRAPHQL_OPERATION_TIMEOUT and GRAPHQL_FIELD_TIMEOUT
+// for the defaults, and GRAPHQL_OPERATION_TIMEOUTS and GRAPHQL_FIELD_TIMEOUTS
+// for overrides written as "name=duration,name=duration", for example
+// "Query.findReviews=3s,Mutation.deleteAccount=20s".
+func TimeoutsFromEnv() (Timeouts, error) {
+	t := DefaultTimeouts
+	var err error
+	if t.Operation, err = durationEnv("GRAPHQL_OPERATION_TIMEOUT", t.Operation); err != nil {
+		return t, err
+	}
+	if t.Field, err = durationEnv("GRAPHQL_FIELD_TIMEOUT", t.Field); err != nil {
+		return t, err
+	}
+	if t.Operations, err = durationsEnv("GRAPHQL_OPERATION_TIMEOUTS"); err != nil {
+		return t, err
+	}
+	if t.Fields, err = durationsEnv("GRAPHQL_FIELD_TIMEOUTS"); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+func durationsEnv(name string) (map[string]time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, nil
+	}
+	m := make(map[string]time.Duration)
+	for _, pair := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not name=duration", name, pair)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		m[key] = d
+	}
+	return m, nil
+}
+
+func (t Timeouts) forOperation(name string) time.Duration {
+	if d, ok := t.Operations[name]; ok {
+		return d
+	}
+	return t.Operation
+}
+
+func (t Timeouts) forField(name string) time.Duration {
+	if d, ok := t.Fields[name]; ok {
+		return d
+	}
+	return t.Field
+}
+
+// ApplyFieldTimeouts wraps every field that has its own resolver so that
+// it runs with a deadline derived from the request context. An error
+// returned after the deadline has passed becomes a TIMEOUT error.
+func ApplyFieldTimeouts(schema *graphql.Schema, t Timeouts) {
+	for _, typ := range schema.TypeMap() {
+		obj, ok := typ.(*graphql.Object)
+		if !ok {
+			continue
+		}
+		for name, def := range obj.Fields() {
+			if def.Resolve == nil {
+				continue
+			}
+			field := obj.Name() + "." + name
+			def.Resolve = withTimeout(field, t.forField(field), def.Resolve)
+		}
+	}
+}
+
+func withTimeout(field string, d time.Duration, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if p.Context == nil {
+			p.Context = context.Background()
+		}
+		if d > 0 {
+			var cancel context.CancelFunc
+			p.Context, cancel = context.WithTimeout(p.Context, d)
+			defer cancel()
+		}
+
+		result, err := next(p)
+		if err != nil && errors.Is(p.Context.Err(), context.DeadlineExceeded) {
+			var appErr *apperr.Error
+			if !errors.As(err, &appErr) || appErr.Code == apperr.Internal {
+				err = apperr.NewTimeout(field + " timed out")
+			}
+		}
+		return result, err
+	}
+}
+
+// OperationTimeout is a graphql-go schema extension that bounds the whole
+// operation, so that the fields of one query share a single deadline.
+type OperationTimeout struct {
+	Timeouts Timeouts
+}
+
+var _ graphql.Extension = OperationTimeout{}
+
+type operationCancelKey struct{}
+
+func (o OperationTimeout) Init(ctx context.Context, p *graphql.Params) context.Context {
+	d := o.Timeouts.forOperation(p.OperationName)
+	if d <= 0 {
+		return ctx
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return context.WithValue(ctx, operationCancelKey{}, cancel)
+}
+
+func cancelOperation(ctx context.Context) {
+	if cancel, ok := ctx.Value(operationCancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+func (OperationTimeout) Name() string {
+	return "timeout"
+}
+
+// ParseDidStart, ValidationDidStart and ExecutionDidStart release the
+// deadline as soon as the operation ends, at whichever stage that is.
+func (OperationTimeout) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(err error) {
+		if err != nil {
+			cancelOperation(ctx)
+		}
+	}
+}
+
+func (OperationTimeout) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func(errs []gqlerrors.FormattedError) {
+		if len(errs) > 0 {
+			cancelOperation(ctx)
+		}
+	}
+}
+
+func (OperationTimeout) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	return ctx, func(*graphql.Result) {
+		cancelOperation(ctx)
+	}
+}
+
+func (OperationTimeout) ResolveFieldDidStart(ctx context.Context, _ *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	return ctx, func(interface{}, error) {}
+}
+
+func (OperationTimeout) HasResult() bool {
+	return false
+}
+
+func (OperationTimeout) GetResult(context.Context) interface{} {
+	return nil
+}
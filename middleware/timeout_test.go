@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"grphqlserver/apperr"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// waitForContext blocks until the resolver's context ends, as a resolver
+// waiting on a slow database would.
+func waitForContext(p graphql.ResolveParams) (interface{}, error) {
+	select {
+	case <-p.Context.Done():
+		return nil, apperr.NewInternal(p.Context.Err())
+	case <-time.After(5 * time.Second):
+		return "finished", nil
+	}
+}
+
+func timeoutSchema(t *testing.T, timeouts Timeouts) graphql.Schema {
+	t.Helper()
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"slow": &graphql.Field{Type: graphql.String, Resolve: waitForContext},
+			},
+		}),
+		Extensions: []graphql.Extension{OperationTimeout{Timeouts: timeouts}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ApplyFieldTimeouts(&schema, timeouts)
+	return schema
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeouts  Timeouts
+		operation string
+	}{
+		{
+			name:     "operation default",
+			timeouts: Timeouts{Operation: 50 * time.Millisecond},
+		},
+		{
+			name:      "named operation",
+			timeouts:  Timeouts{Operation: time.Minute, Operations: map[string]time.Duration{"Slow": 50 * time.Millisecond}},
+			operation: "Slow",
+		},
+		{
+			name:     "field default",
+			timeouts: Timeouts{Field: 50 * time.Millisecond},
+		},
+		{
+			name:     "named field",
+			timeouts: Timeouts{Field: time.Minute, Fields: map[string]time.Duration{"Query.slow": 50 * time.Millisecond}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			result := graphql.Do(graphql.Params{
+				Schema:        timeoutSchema(t, tt.timeouts),
+				RequestString: "query Slow { slow }",
+				OperationName: tt.operation,
+				Context:       context.Background(),
+			})
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("operation took %v", elapsed)
+			}
+			if len(result.Errors) != 1 {
+				t.Fatalf("errors = %v, want one", result.Errors)
+			}
+			formatted := apperr.FormatError(result.Errors[0].OriginalError())
+			if code := formatted.Extensions["code"]; code != apperr.Timeout {
+				t.Errorf("code = %v, want %s", code, apperr.Timeout)
+			}
+		})
+	}
+}
+
+func TestCancelledRequestStopsResolver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	graphql.Do(graphql.Params{
+		Schema:        timeoutSchema(t, DefaultTimeouts),
+		RequestString: "{ slow }",
+		Context:       ctx,
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("resolver ran for %v after the request was cancelled", elapsed)
+	}
+}
+
+func TestTimeoutsFromEnv(t *testing.T) {
+	t.Setenv("GRAPHQL_FIELD_TIMEOUT", "3s")
+	t.Setenv("GRAPHQL_FIELD_TIMEOUTS", "Query.findReviews=1s, Mutation.deleteAccount=20s")
+
+	got, err := TimeoutsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Operation != DefaultTimeouts.Operation || got.forField("Query.books") != 3*time.Second ||
+		got.forField("Query.findReviews") != time.Second || got.forField("Mutation.deleteAccount") != 20*time.Second {
+		t.Errorf("TimeoutsFromEnv() = %+v", got)
+	}
+
+	t.Setenv("GRAPHQL_OPERATION_TIMEOUTS", "findReviews")
+	if _, err := TimeoutsFromEnv(); err == nil {
+		t.Error("expected an error for an override without a duration")
+	}
+}
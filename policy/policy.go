@@ -0,0 +1,158 @@
+// Package policy decides what an actor may do with a resource. Resolvers
+// call Can or Check after loading the resource they are about to change.
+package policy
+
+import (
+	"grphqlserver/apperr"
+	"grphqlserver/auth"
+)
+
+type Action string
+
+const (
+	Create Action = "create"
+	Read   Action = "read"
+	Update Action = "update"
+	Delete Action = "delete"
+	List   Action = "list"
+	Unlock Action = "unlock"
+)
+
+type Kind string
+
+const (
+	Review Kind = "review"
+	Book   Kind = "book"
+	User   Kind = "user"
+	APIKey Kind = "apikey"
+	Shelf  Kind = "shelf"
+)
+
+// Resource identifies what an action is applied to. OwnerID is the hex ID
+// of the user who owns it; for a user resource it is the user's own ID.
+type Resource struct {
+	Kind    Kind
+	OwnerID string
+}
+
+type rule struct {
+	anyone        bool
+	authenticated bool
+	owner         bool
+	roles         []string
+}
+
+var (
+	moderators = []string{auth.RoleModerator, auth.RoleAdmin}
+	admins     = []string{auth.RoleAdmin}
+)
+
+var rules = map[Kind]map[Action]rule{
+	Review: {
+		Read:   {anyone: true},
+		List:   {anyone: true},
+		Create: {authenticated: true},
+		Update: {owner: true, roles: admins},
+		Delete: {owner: true, roles: moderators},
+	},
+	Book: {
+		Read:   {anyone: true},
+		List:   {anyone: true},
+		Create: {authenticated: true},
+		Update: {roles: moderators},
+		Delete: {roles: admins},
+	},
+	User: {
+		Read:   {owner: true, roles: admins},
+		List:   {roles: admins},
+		Update: {owner: true, roles: admins},
+		Delete: {owner: true, roles: admins},
+		Unlock: {roles: admins},
+	},
+	APIKey: {
+		Create: {authenticated: true},
+		List:   {authenticated: true},
+		Delete: {owner: true, roles: admins},
+	},
+	// Shelves are public; only their owner changes them.
+	Shelf: {
+		Read:   {anyone: true},
+		Create: {owner: true},
+		Update: {owner: true},
+		Delete: {owner: true},
+	},
+}
+
+// Scope is the permission an API key needs for action on kind, such as
+// "review:create".
+func Scope(kind Kind, action Action) string {
+	return string(kind) + ":" + string(action)
+}
+
+// ValidScope reports whether s names a permission that can be granted to
+// an API key. Keys can't be given power over other keys.
+func ValidScope(s string) bool {
+	for kind, actions := range rules {
+		if kind == APIKey {
+			continue
+		}
+		for action := range actions {
+			if Scope(kind, action) == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Can reports whether actor may perform action on resource. actor is nil
+// for anonymous callers. Anything without a rule is denied. An API key
+// additionally needs the matching scope and is otherwise treated like the
+// user who created it.
+func Can(actor *auth.Identity, action Action, resource Resource) bool {
+	r, ok := rules[resource.Kind][action]
+	if !ok {
+		return false
+	}
+	if r.anyone {
+		return true
+	}
+	if actor == nil {
+		return false
+	}
+	if actor.Scopes != nil && !hasScope(actor.Scopes, Scope(resource.Kind, action)) {
+		return false
+	}
+	if r.authenticated {
+		return true
+	}
+	if r.owner && resource.OwnerID != "" && resource.OwnerID == actor.UserID {
+		return true
+	}
+	for _, role := range r.roles {
+		if actor.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Check is like Can but returns an error describing the refusal.
+func Check(actor *auth.Identity, action Action, resource Resource) error {
+	if Can(actor, action, resource) {
+		return nil
+	}
+	if actor == nil {
+		return apperr.NewUnauthenticated("unauthenticated: login required")
+	}
+	return apperr.NewForbidden("forbidden: you may not " + string(action) + " this " + string(resource.Kind))
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
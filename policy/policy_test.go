@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"grphqlserver/auth"
+	"testing"
+)
+
+func TestCan(t *testing.T) {
+	const ownerID = "64b000000000000000000001"
+	const otherID = "64b000000000000000000002"
+
+	var (
+		anonymous *auth.Identity
+		owner     = &auth.Identity{UserID: ownerID, Role: auth.RoleUser}
+		other     = &auth.Identity{UserID: otherID, Role: auth.RoleUser}
+		moderator = &auth.Identity{UserID: otherID, Role: auth.RoleModerator}
+		admin     = &auth.Identity{UserID: otherID, Role: auth.RoleAdmin}
+
+		ownerKey     = &auth.Identity{UserID: ownerID, Role: auth.RoleUser, Scopes: []string{"review:update", "book:update"}}
+		moderatorKey = &auth.Identity{UserID: otherID, Role: auth.RoleModerator, Scopes: []string{"book:create", "review:delete"}}
+		unscopedKey  = &auth.Identity{UserID: otherID, Role: auth.RoleAdmin, Scopes: []string{}}
+	)
+
+	review := Resource{Kind: Review, OwnerID: ownerID}
+	anonymisedReview := Resource{Kind: Review}
+	book := Resource{Kind: Book}
+	user := Resource{Kind: User, OwnerID: ownerID}
+	shelf := Resource{Kind: Shelf, OwnerID: ownerID}
+
+	tests := []struct {
+		name     string
+		actor    *auth.Identity
+		action   Action
+		resource Resource
+		want     bool
+	}{
+		{"anonymous reads review", anonymous, Read, review, true},
+		{"anonymous creates review", anonymous, Create, review, false},
+		{"user creates review", other, Create, review, true},
+		{"owner updates review", owner, Update, review, true},
+		{"other user updates review", other, Update, review, false},
+		{"moderator updates review", moderator, Update, review, false},
+		{"admin updates review", admin, Update, review, true},
+		{"owner deletes review", owner, Delete, review, true},
+		{"other user deletes review", other, Delete, review, false},
+		{"moderator deletes review", moderator, Delete, review, true},
+		{"admin deletes review", admin, Delete, review, true},
+		{"nobody owns anonymised review", owner, Delete, anonymisedReview, false},
+		{"moderator deletes anonymised review", moderator, Delete, anonymisedReview, true},
+
+		{"anonymous lists books", anonymous, List, book, true},
+		{"user creates book", owner, Create, book, true},
+		{"user updates book", owner, Update, book, false},
+		{"moderator creates book", moderator, Create, book, true},
+		{"moderator updates book", moderator, Update, book, true},
+		{"moderator deletes book", moderator, Delete, book, false},
+		{"admin deletes book", admin, Delete, book, true},
+
+		{"anonymous reads user", anonymous, Read, user, false},
+		{"user reads self", owner, Read, user, true},
+		{"user reads other user", other, Read, user, false},
+		{"moderator reads other user", moderator, Read, user, false},
+		{"admin reads user", admin, Read, user, true},
+		{"user lists users", owner, List, user, false},
+		{"admin lists users", admin, List, user, true},
+		{"user deletes self", owner, Delete, user, true},
+		{"user deletes other user", other, Delete, user, false},
+		{"admin deletes user", admin, Delete, user, true},
+
+		{"key with scope updates own review", ownerKey, Update, review, true},
+		{"key without scope deletes own review", ownerKey, Delete, review, false},
+		{"key can't exceed its user's role", ownerKey, Update, book, false},
+		{"key without scope creates book", ownerKey, Create, book, false},
+		{"moderator key creates book", moderatorKey, Create, book, true},
+		{"moderator key updates book", moderatorKey, Update, book, false},
+		{"moderator key deletes review", moderatorKey, Delete, review, true},
+		{"unscoped admin key", unscopedKey, Delete, book, false},
+		{"unscoped key still reads public data", unscopedKey, List, book, true},
+		{"key creates API key", unscopedKey, Create, Resource{Kind: APIKey}, false},
+
+		{"anonymous reads shelf", anonymous, Read, shelf, true},
+		{"owner adds to shelf", owner, Create, shelf, true},
+		{"other user adds to shelf", other, Create, shelf, false},
+		{"admin changes shelf", admin, Update, shelf, false},
+		{"owner removes from shelf", owner, Delete, shelf, true},
+		{"key without scope adds to shelf", ownerKey, Create, shelf, false},
+
+		{"unknown action", admin, Action("publish"), book, false},
+		{"unknown kind", admin, Read, Resource{Kind: "magazine"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Can(tt.actor, tt.action, tt.resource); got != tt.want {
+				t.Errorf("Can(%v, %s, %+v) = %v, want %v", tt.actor, tt.action, tt.resource, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(nil, Create, Resource{Kind: Review}); err == nil {
+		t.Error("anonymous create review: expected error")
+	}
+	if err := Check(&auth.Identity{UserID: "x", Role: auth.RoleAdmin}, Delete, Resource{Kind: Book}); err != nil {
+		t.Errorf("admin delete book: unexpected error %v", err)
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for scope, want := range map[string]bool{
+		"review:create": true,
+		"book:delete":   true,
+		"user:unlock":   true,
+		"apikey:create": false,
+		"book:publish":  false,
+		"review":        false,
+		"":              false,
+	} {
+		if got := ValidScope(scope); got != want {
+			t.Errorf("ValidScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package resolvers
+
+import (
+	"context"
+	"grphqlserver/apperr"
+	"grphqlserver/auth"
+	"grphqlserver/logging"
+	"grphqlserver/policy"
+	"grphqlserver/store"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// ReviewsAnonymize keeps a deleted user's reviews but detaches them
+	// from the account.
+	ReviewsAnonymize = "anonymize"
+	// ReviewsDelete removes a deleted user's reviews.
+	ReviewsDelete = "delete"
+)
+
+// DeletedUserReviews decides what happens to a user's reviews when they
+// delete their account.
+var DeletedUserReviews = ReviewsAnonymize
+
+func currentUserID(p graphql.ResolveParams) (primitive.ObjectID, error) {
+	userIDStr, ok := p.Context.Value("userID").(string)
+	if !ok {
+		return primitive.NilObjectID, apperr.NewUnauthenticated("unauthorized: missing user ID")
+	}
+	userID, err := primitive.ObjectIDFromHex(userIDStr)
+	if err != nil {
+		return primitive.NilObjectID, apperr.NewUnauthenticated("invalid user ID format")
+	}
+	return userID, nil
+}
+
+// currentActor returns the caller identified by AuthMiddleware, or nil for
+// anonymous requests.
+func currentActor(p graphql.ResolveParams) *auth.Identity {
+	userID, ok := p.Context.Value("userID").(string)
+	if !ok {
+		return nil
+	}
+	role, _ := p.Context.Value("role").(string)
+	scopes, _ := p.Context.Value("scopes").([]string)
+	return &auth.Identity{UserID: userID, Role: role, Scopes: scopes}
+}
+
+func tokenVersion(user bson.M) int {
+	switch v := user["tokenVersion"].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// LoadSession returns the identity behind a token. It rejects tokens of
+// users who no longer exist or whose token version has moved on, e.g. after
+// a password change.
+func LoadSession(ctx context.Context, userID string, version int) (*auth.Identity, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, apperr.NewUnauthenticated("invalid token")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var user bson.M
+	err = UsersCollection().FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"tokenVersion": 1, "role": 1, "disabledAt": 1})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, apperr.NewUnauthenticated("invalid token")
+	} else if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+
+	if tokenVersion(user) != version {
+		return nil, apperr.NewUnauthenticated("session has been revoked")
+	}
+	if isDisabled(user) {
+		return nil, errAccountDisabled
+	}
+
+	role, _ := user["role"].(string)
+	if role == "" {
+		role = auth.RoleUser
+	}
+	return &auth.Identity{UserID: userID, Role: role}, nil
+}
+
+func MeResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var user bson.M
+	err = UsersCollection().FindOne(ctx, bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"password": 0})).Decode(&user)
+	if err != nil {
+		return nil, apperr.NewNotFound("user not found")
+	}
+
+	return user, nil
+}
+
+func UpdateProfileResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+	collection := UsersCollection()
+
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := policy.Check(currentActor(p), policy.Update, policy.Resource{Kind: policy.User, OwnerID: userID.Hex()}); err != nil {
+		return nil, err
+	}
+
+	var user bson.M
+	err = collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		return nil, apperr.NewNotFound("user not found")
+	}
+
+	set := bson.M{}
+	if displayName, ok := p.Args["displayName"].(string); ok {
+		set["displayName"] = displayName
+	}
+	if bio, ok := p.Args["bio"].(string); ok {
+		set["bio"] = bio
+	}
+
+	emailChanged := false
+	email, hasEmail := p.Args["email"].(string)
+	if hasEmail {
+		var ok bool
+		if email, ok = NormalizeEmail(email); !ok {
+			return nil, apperr.NewBadUserInput("a valid email address is required")
+		}
+	}
+	if hasEmail && email != user["email"] {
+		err := collection.FindOne(ctx, bson.M{"email": email, "_id": bson.M{"$ne": userID}}).Err()
+		if err == nil {
+			return nil, apperr.NewConflict("email already registered")
+		} else if err != mongo.ErrNoDocuments {
+			return nil, apperr.NewInternal(err)
+		}
+		set["email"] = email
+		set["emailVerified"] = false
+		emailChanged = true
+	}
+
+	if len(set) > 0 {
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": set})
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, apperr.NewConflict("email already registered")
+		} else if err != nil {
+			logging.FromContext(p.Context).Error("error updating profile", "error", err)
+			return nil, apperr.NewInternal(err)
+		}
+	}
+
+	if emailChanged {
+		if err := sendEmailVerification(ctx, userID, set["email"].(string)); err != nil {
+			logging.FromContext(p.Context).Error("error sending verification email", "error", err)
+		}
+	}
+
+	var updated bson.M
+	err = collection.FindOne(ctx, bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"password": 0})).Decode(&updated)
+	if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+
+	return updated, nil
+}
+
+func ChangePasswordResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+	collection := UsersCollection()
+
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := policy.Check(currentActor(p), policy.Update, policy.Resource{Kind: policy.User, OwnerID: userID.Hex()}); err != nil {
+		return nil, err
+	}
+
+	oldPassword, _ := p.Args["oldPassword"].(string)
+	newPassword, _ := p.Args["newPassword"].(string)
+
+	var user bson.M
+	err = collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		return nil, apperr.NewNotFound("user not found")
+	}
+
+	hash, _ := user["password"].(string)
+	if match, _, _ := auth.VerifyPassword(hash, oldPassword); !match {
+		return nil, apperr.NewBadUserInput("invalid password")
+	}
+
+	username, _ := user["userName"].(string)
+	if err := auth.Policy.Validate(username, newPassword); err != nil {
+		return nil, apperr.Wrap(apperr.BadUserInput, err)
+	}
+
+	newHash, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+
+	// Bumping the token version revokes every other session; the caller
+	// gets a fresh token for theirs.
+	var updated bson.M
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set": bson.M{"password": newHash},
+			"$inc": bson.M{"tokenVersion": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		logging.FromContext(p.Context).Error("error changing password", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	return auth.GenerateToken(userID.Hex(), tokenVersion(updated))
+}
+
+func DeleteAccountResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+	collection := UsersCollection()
+
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := policy.Check(currentActor(p), policy.Delete, policy.Resource{Kind: policy.User, OwnerID: userID.Hex()}); err != nil {
+		return nil, err
+	}
+
+	password, _ := p.Args["password"].(string)
+	confirmUserName, _ := p.Args["confirmUserName"].(string)
+
+	var user bson.M
+	err = collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		return nil, apperr.NewNotFound("user not found")
+	}
+
+	// Accounts created through single sign-on have no password; their
+	// owners confirm by typing their username instead.
+	if hash, ok := user["password"].(string); ok && hash != "" {
+		if match, _, _ := auth.VerifyPassword(hash, password); !match {
+			return nil, apperr.NewBadUserInput("invalid password")
+		}
+	} else if userName, _ := user["userName"].(string); confirmUserName == "" || confirmUserName != userName {
+		return nil, apperr.NewBadUserInput("confirm by giving your username as confirmUserName")
+	}
+
+	switch DeletedUserReviews {
+	case ReviewsDelete:
+		_, err = ReviewCollection().DeleteMany(ctx, bson.M{"userID": userID})
+	default:
+		_, err = ReviewCollection().UpdateMany(ctx, bson.M{"userID": userID},
+			bson.M{"$set": bson.M{"userID": nil}})
+	}
+	if err != nil {
+		logging.FromContext(p.Context).Error("error handling reviews of deleted account", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	for _, c := range []store.Collection{PasswordResetsCollection(), EmailVerificationsCollection(), ApiKeysCollection(), ShelvesCollection()} {
+		if _, err := c.DeleteMany(ctx, bson.M{"userID": userID}); err != nil {
+			logging.FromContext(p.Context).Error("error removing data of deleted account", "error", err)
+		}
+	}
+
+	_, err = collection.DeleteOne(ctx, bson.M{"_id": userID})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error deleting account", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,79 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"grphqlserver/apperr"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDeleteAccountWithoutPassword(t *testing.T) {
+	saved, savedMongo := Store, mongoStore
+	defer func() { Store, mongoStore = saved, savedMongo }()
+	UseStore(NewMemoryStore())
+
+	ctx := context.Background()
+	res, err := UsersCollection().InsertOne(ctx, bson.M{
+		"userName": "sso-user", "role": "user", "oidcIssuer": "https://idp.example.com", "oidcSubject": "s1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := res.InsertedID.(primitive.ObjectID)
+	if _, err := ApiKeysCollection().InsertOne(ctx, bson.M{"userID": userID, "keyHash": "h1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleteAccount := func(args map[string]interface{}) error {
+		_, err := DeleteAccountResolver(graphql.ResolveParams{
+			Context: context.WithValue(ctx, "userID", userID.Hex()),
+			Args:    args,
+		})
+		return err
+	}
+
+	for _, args := range []map[string]interface{}{
+		{},
+		{"password": ""},
+		{"confirmUserName": "someone-else"},
+	} {
+		var appErr *apperr.Error
+		if err := deleteAccount(args); !errors.As(err, &appErr) || appErr.Code != apperr.BadUserInput {
+			t.Errorf("deleteAccount(%v) = %v, want a BAD_USER_INPUT error", args, err)
+		}
+	}
+
+	if err := deleteAccount(map[string]interface{}{"confirmUserName": "sso-user"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"users", "api_keys"} {
+		n, err := collection(c).CountDocuments(ctx, bson.M{})
+		if err != nil || n != 0 {
+			t.Errorf("%d documents left in %s (%v)", n, c, err)
+		}
+	}
+}
+
+func TestAccountResolversRequireLogin(t *testing.T) {
+	saved, savedMongo := Store, mongoStore
+	defer func() { Store, mongoStore = saved, savedMongo }()
+	UseStore(NewMemoryStore())
+
+	resolvers := map[string]graphql.FieldResolveFn{
+		"me":            MeResolver,
+		"updateProfile": UpdateProfileResolver,
+		"deleteAccount": DeleteAccountResolver,
+		"apiKeys":       ApiKeysResolver,
+	}
+	for name, resolve := range resolvers {
+		_, err := resolve(graphql.ResolveParams{Context: context.Background()})
+		var appErr *apperr.Error
+		if !errors.As(err, &appErr) || appErr.Code != apperr.Unauthenticated {
+			t.Errorf("%s without a user = %v, want an UNAUTHENTICATED error", name, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package resolvers
+
+import (
+	"context"
+	"grphqlserver/apperr"
+	"grphqlserver/auth"
+	"grphqlserver/lockout"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errAccountDisabled = apperr.NewForbidden("account is disabled")
+
+// isDisabled reports whether an operator has disabled the user. Disabled
+// users can't log in and their sessions and API keys are rejected.
+func isDisabled(user bson.M) bool {
+	v, ok := user["disabledAt"]
+	return ok && v != nil
+}
+
+func findUserID(ctx context.Context, username string) (primitive.ObjectID, error) {
+	var user struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err := UsersCollection().FindOne(ctx, bson.M{"userName": username}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, apperr.NewNotFound("user " + username + " not found")
+	} else if err != nil {
+		return primitive.NilObjectID, apperr.NewInternal(err)
+	}
+	return user.ID, nil
+}
+
+// SetPassword replaces a user's password without a reset token, revokes
+// their sessions and lifts any login lockout.
+func SetPassword(ctx context.Context, username, password string) error {
+	id, err := findUserID(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := auth.Policy.Validate(username, password); err != nil {
+		return apperr.Wrap(apperr.BadUserInput, err)
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return apperr.NewInternal(err)
+	}
+
+	_, err = UsersCollection().UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{"password": hashedPassword},
+			"$inc": bson.M{"tokenVersion": 1},
+		})
+	if err != nil {
+		return apperr.NewInternal(err)
+	}
+
+	_, err = PasswordResetsCollection().DeleteMany(ctx, bson.M{
+		"userID": id,
+		"usedAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return apperr.NewInternal(err)
+	}
+	if err := LoginGuard.Reset(ctx, lockout.AccountKey(username)); err != nil {
+		return apperr.NewInternal(err)
+	}
+	return nil
+}
+
+// DisableUser blocks a user from logging in and revokes their sessions
+// and API keys. Their data is kept.
+func DisableUser(ctx context.Context, username string) error {
+	id, err := findUserID(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = UsersCollection().UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{"disabledAt": now},
+			"$inc": bson.M{"tokenVersion": 1},
+		})
+	if err != nil {
+		return apperr.NewInternal(err)
+	}
+
+	_, err = ApiKeysCollection().UpdateMany(ctx,
+		bson.M{"userID": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": now}})
+	if err != nil {
+		return apperr.NewInternal(err)
+	}
+	return nil
+}
+
+// EnableUser lets a disabled user log in again. Revoked API keys stay
+// revoked.
+func EnableUser(ctx context.Context, username string) error {
+	id, err := findUserID(ctx, username)
+	if err != nil {
+		return err
+	}
+	_, err = UsersCollection().UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$unset": bson.M{"disabledAt": ""}})
+	if err != nil {
+		return apperr.NewInternal(err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package resolvers
+
+import (
+	"context"
+	"grphqlserver/apperr"
+	"grphqlserver/auth"
+	"grphqlserver/logging"
+	"grphqlserver/policy"
+	"grphqlserver/store"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiKeyPrefix = "gk_"
+
+func ApiKeysCollection() store.Collection {
+	return collection("api_keys")
+}
+
+// LoadAPIKey returns the identity of a live API key. The key acts as the
+// user who created it, limited to the key's scopes.
+func LoadAPIKey(ctx context.Context, key string) (*auth.Identity, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, apperr.NewUnauthenticated("invalid API key")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var apiKey bson.M
+	err := ApiKeysCollection().FindOneAndUpdate(ctx,
+		bson.M{
+			"keyHash":   auth.HashOpaqueToken(key),
+			"revokedAt": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"expiresAt": nil},
+				bson.M{"expiresAt": bson.M{"$gt": now}},
+			},
+		},
+		bson.M{"$set": bson.M{"lastUsedAt": now}},
+	).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		return nil, apperr.NewUnauthenticated("invalid API key")
+	} else if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+
+	var user bson.M
+	err = UsersCollection().FindOne(ctx, bson.M{"_id": apiKey["userID"]},
+		options.FindOne().SetProjection(bson.M{"role": 1, "disabledAt": 1})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, apperr.NewUnauthenticated("invalid API key")
+	} else if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+	if isDisabled(user) {
+		return nil, errAccountDisabled
+	}
+
+	role, _ := user["role"].(string)
+	if role == "" {
+		role = auth.RoleUser
+	}
+	scopes := []string{}
+	if a, ok := apiKey["scopes"].(bson.A); ok {
+		for _, s := range a {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	return &auth.Identity{
+		UserID: apiKey["userID"].(primitive.ObjectID).Hex(),
+		Role:   role,
+		Scopes: scopes,
+	}, nil
+}
+
+func CreateApiKeyResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	if err := policy.Check(currentActor(p), policy.Create, policy.Resource{Kind: policy.APIKey}); err != nil {
+		return nil, err
+	}
+
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := p.Args["name"].(string)
+	if name == "" {
+		return nil, apperr.NewBadUserInput("name cannot be empty")
+	}
+
+	scopes := []string{}
+	if args, ok := p.Args["scopes"].([]interface{}); ok {
+		for _, s := range args {
+			scope, _ := s.(string)
+			if !policy.ValidScope(scope) {
+				return nil, apperr.NewBadUserInput("invalid scope: " + scope)
+			}
+			scopes = append(scopes, scope)
+		}
+	}
+
+	var expiresAt interface{}
+	if t, ok := p.Args["expiresAt"].(time.Time); ok {
+		if !t.After(time.Now()) {
+			return nil, apperr.NewBadUserInput("expiresAt must be in the future")
+		}
+		expiresAt = t
+	}
+
+	secret, _, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey := bson.M{
+		"userID":    userID,
+		"name":      name,
+		"scopes":    scopes,
+		"keyHash":   auth.HashOpaqueToken(key),
+		"prefix":    key[:len(apiKeyPrefix)+6],
+		"createdAt": time.Now(),
+		"expiresAt": expiresAt,
+	}
+
+	res, err := ApiKeysCollection().InsertOne(ctx, apiKey)
+	if err != nil {
+		logging.FromContext(p.Context).Error("error inserting API key", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	delete(apiKey, "keyHash")
+	apiKey["_id"] = res.InsertedID
+	// The plain key is only ever returned here.
+	apiKey["key"] = key
+	return apiKey, nil
+}
+
+func RevokeApiKeyResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+	collection := ApiKeysCollection()
+
+	id, ok := p.Args["_id"].(primitive.ObjectID)
+	if !ok {
+		return nil, apperr.NewBadUserInput("missing API key ID")
+	}
+
+	var apiKey bson.M
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&apiKey)
+	if err != nil {
+		return nil, apperr.NewNotFound("API key not found")
+	}
+
+	resource := policy.Resource{Kind: policy.APIKey}
+	if owner, ok := apiKey["userID"].(primitive.ObjectID); ok {
+		resource.OwnerID = owner.Hex()
+	}
+	if err := policy.Check(currentActor(p), policy.Delete, resource); err != nil {
+		return nil, err
+	}
+
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error revoking API key", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	return true, nil
+}
+
+func ApiKeysResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	if err := policy.Check(currentActor(p), policy.List, policy.Resource{Kind: policy.APIKey}); err != nil {
+		return nil, err
+	}
+
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := ApiKeysCollection().Find(ctx, bson.M{"userID": userID},
+		options.Find().SetProjection(bson.M{"keyHash": 0}).SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		logging.FromContext(p.Context).Error("error finding API keys", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+	defer cursor.Close(ctx)
+
+	var keys []bson.M
+	if err = cursor.All(ctx, &keys); err != nil {
+		logging.FromContext(p.Context).Error("error reading API keys from cursor", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+	for _, k := range keys {
+		convertDates(k)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,217 @@
+package resolvers_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"grphqlserver/apperr"
+	"grphqlserver/middleware"
+	"grphqlserver/resolvers"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+// stallingServer speaks just enough of the MongoDB wire protocol to pass
+// the driver's handshake. It never answers a find; instead it reports when
+// one arrives and when the client gives up on it by closing the connection.
+type stallingServer struct {
+	ln        net.Listener
+	findStart chan struct{}
+	findAbort chan struct{}
+}
+
+func newStallingServer(t *testing.T) *stallingServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stallingServer{ln: ln, findStart: make(chan struct{}, 1), findAbort: make(chan struct{}, 1)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *stallingServer) uri() string {
+	return "mongodb://" + s.ln.Addr().String() + "/?directConnection=true"
+}
+
+func (s *stallingServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		size := binary.LittleEndian.Uint32(header[0:])
+		requestID := binary.LittleEndian.Uint32(header[4:])
+		opCode := binary.LittleEndian.Uint32(header[12:])
+		body := make([]byte, size-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+
+		var cmd bson.Raw
+		switch opCode {
+		case opQuery:
+			// flags, then the collection name as a cstring, then skip and limit
+			i := 4
+			for body[i] != 0 {
+				i++
+			}
+			cmd = bson.Raw(body[i+1+8:])
+		case opMsg:
+			// flags, then a kind 0 section holding the command
+			cmd = bson.Raw(body[5:])
+		default:
+			return
+		}
+		name := cmd.Index(0).Key()
+
+		if name == "find" {
+			s.findStart <- struct{}{}
+			// Stall until the client abandons the command.
+			io.Copy(io.Discard, conn)
+			s.findAbort <- struct{}{}
+			return
+		}
+
+		reply := bson.M{"ok": 1}
+		switch name {
+		case "hello", "isMaster", "ismaster":
+			reply = bson.M{
+				"ok": 1, "ismaster": true, "helloOk": true,
+				"minWireVersion": 0, "maxWireVersion": 21,
+				"maxBsonObjectSize": 16 << 20, "maxMessageSizeBytes": 48 << 20,
+				"maxWriteBatchSize": 100000, "localTime": time.Now(),
+			}
+		}
+		if err := writeReply(conn, opCode, requestID, reply); err != nil {
+			return
+		}
+	}
+}
+
+func writeReply(conn net.Conn, opCode, responseTo uint32, reply bson.M) error {
+	doc, err := bson.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if opCode == opQuery {
+		// flags, cursor ID, starting from, number returned
+		body = make([]byte, 20)
+		binary.LittleEndian.PutUint32(body[16:], 1)
+		opCode = opReply
+	} else {
+		// flags, section kind 0
+		body = make([]byte, 5)
+	}
+	body = append(body, doc...)
+
+	msg := make([]byte, 16, 16+len(body))
+	binary.LittleEndian.PutUint32(msg[0:], uint32(16+len(body)))
+	binary.LittleEndian.PutUint32(msg[8:], responseTo)
+	binary.LittleEndian.PutUint32(msg[12:], opCode)
+	_, err = conn.Write(append(msg, body...))
+	return err
+}
+
+func TestResolverStopsMongoWorkWhenRequestEnds(t *testing.T) {
+	server := newStallingServer(t)
+	if err := resolvers.Connect(context.Background(), server.uri()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resolvers.Disconnect(context.Background()) })
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"books": &graphql.Field{Type: graphql.String, Resolve: resolvers.BookResolver},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware.ApplyFieldTimeouts(&schema, middleware.Timeouts{
+		Field:  5 * time.Second,
+		Fields: map[string]time.Duration{"Query.books": 200 * time.Millisecond},
+	})
+	books := schema.QueryType().Fields()["books"].Resolve
+
+	tests := []struct {
+		name string
+		// end stops the request once the find has reached the server.
+		end      func(cancel context.CancelFunc)
+		wantCode apperr.Code
+	}{
+		{
+			name:     "client disconnects",
+			end:      func(cancel context.CancelFunc) { cancel() },
+			wantCode: apperr.Canceled,
+		},
+		{
+			name:     "field timeout",
+			end:      func(context.CancelFunc) {},
+			wantCode: apperr.Timeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := books(graphql.ResolveParams{
+					Context: ctx,
+					Info:    graphql.ResolveInfo{FieldName: "books", ParentType: schema.QueryType()},
+				})
+				done <- err
+			}()
+
+			select {
+			case <-server.findStart:
+			case <-time.After(5 * time.Second):
+				t.Fatal("find never reached the server")
+			}
+			tt.end(cancel)
+
+			select {
+			case err := <-done:
+				var appErr *apperr.Error
+				if !errors.As(err, &appErr) || appErr.Code != tt.wantCode {
+					t.Errorf("resolver error = %v, want code %s", err, tt.wantCode)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("resolver kept waiting for Mongo after the request ended")
+			}
+
+			select {
+			case <-server.findAbort:
+			case <-time.After(2 * time.Second):
+				t.Fatal("the driver didn't abandon the find on the server")
+			}
+		})
+	}
+}
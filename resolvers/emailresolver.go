@@ -0,0 +1,124 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"grphqlserver/apperr"
+	"grphqlserver/auth"
+	"grphqlserver/logging"
+	"grphqlserver/mailer"
+	"grphqlserver/store"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+// RequireVerifiedEmail stops users who haven't confirmed their email
+// address from posting reviews.
+var RequireVerifiedEmail = false
+
+func EmailVerificationsCollection() store.Collection {
+	return collection("email_verifications")
+}
+
+// NormalizeEmail checks that s is a bare email address and returns it in
+// the lower-case form used for storage and lookups.
+func NormalizeEmail(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) > 254 {
+		return "", false
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return "", false
+	}
+	return strings.ToLower(s), true
+}
+
+func sendEmailVerification(ctx context.Context, userID primitive.ObjectID, email string) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	_, err = EmailVerificationsCollection().InsertOne(ctx, bson.M{
+		"userID":    userID,
+		"email":     email,
+		"tokenHash": hash,
+		"expiresAt": time.Now().Add(emailVerificationTTL),
+		"createdAt": time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return Mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Use this token with the verifyEmail mutation to confirm your address:\n\n%s\n\n"+
+			"The token expires in %s.\n", token, emailVerificationTTL),
+	})
+}
+
+func VerifyEmailResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	token, _ := p.Args["token"].(string)
+	if token == "" {
+		return nil, apperr.NewBadUserInput("token cannot be empty")
+	}
+
+	var verification bson.M
+	err := EmailVerificationsCollection().FindOneAndUpdate(ctx,
+		bson.M{
+			"tokenHash": auth.HashOpaqueToken(token),
+			"usedAt":    bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": time.Now()},
+		},
+		bson.M{"$set": bson.M{"usedAt": time.Now()}},
+	).Decode(&verification)
+	if err == mongo.ErrNoDocuments {
+		return nil, apperr.NewBadUserInput("invalid or expired verification token")
+	} else if err != nil {
+		logging.FromContext(p.Context).Error("error claiming email verification token", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	// Only confirm the address the token was sent to, in case the user has
+	// changed it since.
+	res, err := UsersCollection().UpdateOne(ctx,
+		bson.M{"_id": verification["userID"], "email": verification["email"]},
+		bson.M{"$set": bson.M{"emailVerified": true}})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error marking email as verified", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+	if res.MatchedCount == 0 {
+		return nil, apperr.NewBadUserInput("invalid or expired verification token")
+	}
+
+	return true, nil
+}
+
+func requireVerifiedEmail(ctx context.Context, userID primitive.ObjectID) error {
+	if !RequireVerifiedEmail {
+		return nil
+	}
+
+	var user bson.M
+	err := UsersCollection().FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		return apperr.NewNotFound("user not found")
+	}
+	if verified, _ := user["emailVerified"].(bool); !verified {
+		return apperr.NewForbidden("email address must be verified first")
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package resolvers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Loaders is a graphql-go schema extension that gives each operation its
+// own batch loaders. Resolvers of list items register what they need and
+// return a thunk; graphql-go calls the thunks once the list's other fields
+// have resolved, so the first one loads for the whole list in one query.
+// Loads use the operation's context rather than the field's, since one
+// load serves many fields.
+type Loaders struct{}
+
+var _ graphql.Extension = Loaders{}
+
+type loadersKey struct{}
+
+type loaders struct {
+	shelfCounts *shelfCountsLoader
+}
+
+func (Loaders) Init(ctx context.Context, _ *graphql.Params) context.Context {
+	return ctx
+}
+
+func (Loaders) Name() string {
+	return "loaders"
+}
+
+func (Loaders) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(error) {}
+}
+
+func (Loaders) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func([]gqlerrors.FormattedError) {}
+}
+
+// ExecutionDidStart creates the loaders, once every extension's Init has
+// set up the operation's context.
+func (Loaders) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	l := &loaders{shelfCounts: &shelfCountsLoader{ctx: ctx}}
+	return context.WithValue(ctx, loadersKey{}, l), func(*graphql.Result) {}
+}
+
+func (Loaders) ResolveFieldDidStart(ctx context.Context, _ *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	return ctx, func(interface{}, error) {}
+}
+
+func (Loaders) HasResult() bool {
+	return false
+}
+
+func (Loaders) GetResult(context.Context) interface{} {
+	return nil
+}
+
+// shelfCountsFor returns the operation's shelf counts loader, or a new one
+// outside an operation run with the Loaders extension.
+func shelfCountsFor(ctx context.Context) *shelfCountsLoader {
+	if l, ok := ctx.Value(loadersKey{}).(*loaders); ok {
+		return l.shelfCounts
+	}
+	return &shelfCountsLoader{ctx: ctx}
+}
+
+// shelfCountsLoader counts the users who have books on each built-in
+// shelf, for all the books requested so far in one aggregation.
+type shelfCountsLoader struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	pending []primitive.ObjectID
+	loaded  map[primitive.ObjectID]shelfCountsResult
+}
+
+type shelfCountsResult struct {
+	counts bson.M
+	err    error
+}
+
+// load registers bookID and returns a thunk for its counts.
+func (l *shelfCountsLoader) load(bookID primitive.ObjectID) func() (interface{}, error) {
+	l.mu.Lock()
+	l.pending = append(l.pending, bookID)
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if r, ok := l.loaded[bookID]; ok {
+			return r.counts, r.err
+		}
+		batch := l.pending
+		l.pending = nil
+		counts, err := countShelves(l.ctx, batch)
+		if l.loaded == nil {
+			l.loaded = map[primitive.ObjectID]shelfCountsResult{}
+		}
+		for _, id := range batch {
+			r := shelfCountsResult{err: err}
+			if err == nil {
+				r.counts = counts[id]
+			}
+			l.loaded[id] = r
+		}
+		r := l.loaded[bookID]
+		return r.counts, r.err
+	}
+}
+
+// countShelves counts the entries on each built-in shelf for bookIDs.
+func countShelves(ctx context.Context, bookIDs []primitive.ObjectID) (map[primitive.ObjectID]bson.M, error) {
+	fields := map[string]string{
+		ShelfWantToRead: "wantToRead",
+		ShelfReading:    "reading",
+		ShelfRead:       "read",
+	}
+	counts := make(map[primitive.ObjectID]bson.M, len(bookIDs))
+	for _, id := range bookIDs {
+		counts[id] = bson.M{"wantToRead": int64(0), "reading": int64(0), "read": int64(0)}
+	}
+
+	cursor, err := ShelvesCollection().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookID": bson.M{"$in": bookIDs}, "shelf": bson.M{"$in": StatusShelves}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"bookID": "$bookID", "shelf": "$shelf"},
+			"n":   bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ID struct {
+			BookID primitive.ObjectID `bson:"bookID"`
+			Shelf  string             `bson:"shelf"`
+		} `bson:"_id"`
+		N int64 `bson:"n"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if c, ok := counts[g.ID.BookID]; ok {
+			c[fields[g.ID.Shelf]] = g.N
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,367 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grphqlserver/store"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A migration brings the database up to date with what the code expects.
+// Applied migrations are recorded by ID in the migrations collection, so
+// IDs must never change once released; append new ones to the end.
+type migration struct {
+	ID string
+	Up func(ctx context.Context, db *mongo.Database) error
+}
+
+var migrations = []migration{
+	{
+		// Addresses stored before NormalizeEmail are normalized first, so
+		// that the index is built on the form lookups use, and accounts
+		// that would share an address are set aside rather than failing
+		// the build.
+		ID: "001_users_email_unique",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collisions, err := normalizeEmails(ctx, db.Collection("users"), db.Collection("email_verifications"))
+			if err != nil {
+				return err
+			}
+			for _, c := range collisions {
+				slog.Warn("email address is another account's once normalized; moved it to emailConflict",
+					"user_id", c.UserID.Hex(), "email", c.Email, "other_user_id", c.OtherID.Hex())
+			}
+			_, err = db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string", "$gt": ""}}),
+			})
+			return err
+		},
+	},
+	{
+		ID: "002_token_lookups",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{"email_verifications", "password_resets"} {
+				_, err := db.Collection(name).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+				})
+				if err != nil {
+					return err
+				}
+			}
+			_, err := db.Collection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "keyHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "userID", Value: 1}}},
+			})
+			return err
+		},
+	},
+	{
+		ID: "003_reviews_by_book_and_user",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("reviews").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{Keys: bson.D{{Key: "bookID", Value: 1}}},
+				{Keys: bson.D{{Key: "userID", Value: 1}}},
+			})
+			return err
+		},
+	},
+	{
+		ID: "004_shelves",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("shelves").Indexes().CreateMany(ctx, shelfIndexes())
+			return err
+		},
+	},
+}
+
+// emailCollision is a user whose email address, once normalized, is
+// another user's address.
+type emailCollision struct {
+	UserID  primitive.ObjectID
+	Email   string
+	OtherID primitive.ObjectID
+}
+
+// normalizeEmails brings the addresses stored before NormalizeEmail to its
+// lower-case form. Where several users share an address once normalized,
+// a verified one keeps it, or else the oldest; the others have it moved to
+// emailConflict and are returned for an operator to resolve. Pending
+// verifications follow their user, as they must match its address.
+func normalizeEmails(ctx context.Context, users, verifications store.Collection) ([]emailCollision, error) {
+	cursor, err := users.Find(ctx, bson.M{"email": bson.M{"$gt": ""}},
+		options.Find().SetProjection(bson.M{"email": 1, "emailVerified": 1}).
+			SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	type account struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		Email    string             `bson:"email"`
+		Verified bool               `bson:"emailVerified"`
+	}
+	byEmail := map[string][]account{}
+	var order []string
+	for cursor.Next(ctx) {
+		var a account
+		if err := cursor.Decode(&a); err != nil {
+			return nil, err
+		}
+		normalized := strings.ToLower(strings.TrimSpace(a.Email))
+		if _, seen := byEmail[normalized]; !seen {
+			order = append(order, normalized)
+		}
+		byEmail[normalized] = append(byEmail[normalized], a)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	var collisions []emailCollision
+	setAside := bson.A{}
+	for _, email := range order {
+		accounts := byEmail[email]
+		keeper := accounts[0]
+		for _, a := range accounts {
+			if a.Verified {
+				keeper = a
+				break
+			}
+		}
+		// Set the others aside first, so that the keeper's address is
+		// free when it is normalized.
+		for _, a := range accounts {
+			if a.ID == keeper.ID {
+				continue
+			}
+			_, err := users.UpdateOne(ctx, bson.M{"_id": a.ID}, bson.M{
+				"$set":   bson.M{"emailConflict": a.Email, "emailVerified": false},
+				"$unset": bson.M{"email": ""},
+			})
+			if err != nil {
+				return nil, err
+			}
+			collisions = append(collisions, emailCollision{UserID: a.ID, Email: a.Email, OtherID: keeper.ID})
+			setAside = append(setAside, a.ID)
+		}
+		if keeper.Email != email {
+			_, err := users.UpdateOne(ctx, bson.M{"_id": keeper.ID}, bson.M{"$set": bson.M{"email": email}})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, err = verifications.DeleteMany(ctx, bson.M{"userID": bson.M{"$in": setAside}})
+	if err != nil {
+		return nil, err
+	}
+	return collisions, lowercaseVerifications(ctx, verifications)
+}
+
+// lowercaseVerifications normalizes the addresses of pending email
+// verifications.
+func lowercaseVerifications(ctx context.Context, verifications store.Collection) error {
+	cursor, err := verifications.Find(ctx, bson.M{"email": bson.M{"$regex": `[A-Z]|^\s|\s$`}},
+		options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Email string             `bson:"email"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		normalized := strings.ToLower(strings.TrimSpace(doc.Email))
+		if _, err := verifications.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"email": normalized}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MigrationsCollection() store.Collection {
+	return collection("migrations")
+}
+
+// PendingMigrations returns the IDs of migrations that haven't been applied.
+// Stores other than MongoDB have nothing to migrate.
+func PendingMigrations(ctx context.Context) ([]string, error) {
+	if mongoStore == nil {
+		return nil, nil
+	}
+	cursor, err := MigrationsCollection().Find(ctx, bson.D{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var applied []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(applied))
+	for _, a := range applied {
+		done[a.ID] = true
+	}
+
+	var pending []string
+	for _, m := range migrations {
+		if !done[m.ID] {
+			pending = append(pending, m.ID)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies pending migrations in order and returns the IDs it
+// applied. It stops at the first failure.
+func Migrate(ctx context.Context) ([]string, error) {
+	pending, err := PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		if !contains(pending, m.ID) {
+			continue
+		}
+		if err := m.Up(ctx, database()); err != nil {
+			return applied, err
+		}
+		_, err := MigrationsCollection().InsertOne(ctx, bson.M{"_id": m.ID, "appliedAt": time.Now()})
+		if err != nil {
+			return applied, err
+		}
+		slog.Info("applied migration", "migration", m.ID)
+		applied = append(applied, m.ID)
+	}
+	return applied, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// indexes are the indexes the code relies on, as created by the
+// migrations above. Keep them in step when a migration adds an index.
+var indexes = map[string][]mongo.IndexModel{
+	"users": {{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string", "$gt": ""}}),
+	}},
+	"email_verifications": tokenIndexes(),
+	"password_resets":     tokenIndexes(),
+	"api_keys": {
+		{Keys: bson.D{{Key: "keyHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userID", Value: 1}}},
+	},
+	"reviews": {
+		{Keys: bson.D{{Key: "bookID", Value: 1}}},
+		{Keys: bson.D{{Key: "userID", Value: 1}}},
+	},
+	"shelves": shelfIndexes(),
+}
+
+func tokenIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+}
+
+// shelfIndexes keep a book on a shelf once per user, and on one built-in
+// shelf, and serve Book.shelfCounts.
+func shelfIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "bookID", Value: 1}, {Key: "shelf", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "userID", Value: 1}, {Key: "bookID", Value: 1}, {Key: "builtIn", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"builtIn": bson.M{"$exists": true}}),
+		},
+		{Keys: bson.D{{Key: "bookID", Value: 1}, {Key: "shelf", Value: 1}}},
+	}
+}
+
+// NewMemoryStore returns an empty store.Memory with the unique keys of
+// the indexes above, for use with UseStore.
+func NewMemoryStore() *store.Memory {
+	m := store.NewMemory()
+	for name, models := range indexes {
+		for _, model := range models {
+			if model.Options == nil || model.Options.Unique == nil || !*model.Options.Unique {
+				continue
+			}
+			var fields []string
+			for _, key := range model.Keys.(bson.D) {
+				fields = append(fields, key.Key)
+			}
+			// The store is empty, so there is nothing to violate the key.
+			_ = m.Unique(name, fields...)
+		}
+	}
+	return m
+}
+
+// Reindex creates any of the expected indexes that are missing. With drop,
+// it first drops every index except _id so that all of them are rebuilt.
+func Reindex(ctx context.Context, drop bool) error {
+	if mongoStore == nil {
+		return nil
+	}
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		view := database().Collection(name).Indexes()
+		if drop {
+			if _, err := view.DropAll(ctx); err != nil && !isNamespaceNotFound(err) {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if _, err := view.CreateMany(ctx, indexes[name]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		slog.Info("indexed collection", "collection", name, "indexes", len(indexes[name]))
+	}
+	return nil
+}
+
+func isNamespaceNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 26
+}
@@ -0,0 +1,94 @@
+package resolvers
+
+import (
+	"context"
+	"grphqlserver/store"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeEmailsBeforeIndexing(t *testing.T) {
+	ctx := context.Background()
+	m := store.NewMemory()
+	users, verifications := m.Collection("users"), m.Collection("email_verifications")
+
+	// ObjectIDs grow, so the names are in order of creation.
+	ids := map[string]primitive.ObjectID{}
+	for _, u := range []struct {
+		name, email string
+		verified    bool
+	}{
+		{"alice", "Alice@Example.com", false},
+		{"bob", "bob@example.com", false},
+		{"bobby", "BOB@example.com", true},
+		{"carol", " carol@example.com ", false},
+		{"dave", "dave@example.com", false},
+		{"dave2", "dave@example.com", false},
+	} {
+		ids[u.name] = primitive.NewObjectID()
+		_, err := users.InsertOne(ctx, bson.M{"_id": ids[u.name], "userName": u.name, "email": u.email, "emailVerified": u.verified})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := verifications.InsertOne(ctx, bson.M{"userID": ids[u.name], "email": u.email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Unique("users", "email"); err == nil {
+		t.Fatal("the unique key was built over duplicate addresses")
+	}
+
+	collisions, err := normalizeEmails(ctx, users, verifications)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := map[primitive.ObjectID]primitive.ObjectID{}
+	for _, c := range collisions {
+		reported[c.UserID] = c.OtherID
+	}
+	want := map[primitive.ObjectID]primitive.ObjectID{ids["bob"]: ids["bobby"], ids["dave2"]: ids["dave"]}
+	if len(reported) != len(want) || reported[ids["bob"]] != ids["bobby"] || reported[ids["dave2"]] != ids["dave"] {
+		t.Errorf("collisions = %+v, want bob set aside for bobby, who is verified, and dave2 for dave, who is older", collisions)
+	}
+
+	// The index now builds and holds.
+	if err := m.Unique("users", "email"); err != nil {
+		t.Fatalf("unique key after normalizing: %v", err)
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"userName": "eve", "email": "alice@example.com"}); err == nil {
+		t.Error("duplicate address was inserted after the key was built")
+	}
+
+	for name, email := range map[string]string{
+		"alice": "alice@example.com",
+		"bob":   "",
+		"bobby": "bob@example.com",
+		"carol": "carol@example.com",
+		"dave":  "dave@example.com",
+		"dave2": "",
+	} {
+		var user bson.M
+		if err := users.FindOne(ctx, bson.M{"_id": ids[name]}).Decode(&user); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := user["email"].(string)
+		if got != email {
+			t.Errorf("%s's email = %q, want %q", name, got, email)
+		}
+		var verification bson.M
+		err := verifications.FindOne(ctx, bson.M{"userID": ids[name]}).Decode(&verification)
+		if email == "" {
+			if user["emailConflict"] == nil || err == nil {
+				t.Errorf("%s: emailConflict = %v, verification error = %v; want the address kept aside and its verification dropped", name, user["emailConflict"], err)
+			}
+		} else if err != nil || verification["email"] != email {
+			t.Errorf("%s's pending verification is for %v (%v), want %q", name, verification["email"], err, email)
+		}
+	}
+
+	if collisions, err := normalizeEmails(ctx, users, verifications); err != nil || len(collisions) != 0 {
+		t.Errorf("second run: %v, %v", collisions, err)
+	}
+}
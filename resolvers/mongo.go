@@ -0,0 +1,111 @@
+package resolvers
+
+import (
+	"context"
+	"grphqlserver/cache"
+	"grphqlserver/metrics"
+	"grphqlserver/store"
+	"grphqlserver/tracing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is where resolvers read and write data. Connect sets it to
+// MongoDB; tests and tools may substitute another implementation.
+var Store store.Store
+
+var mongoStore *store.Mongo
+
+// Connect creates the MongoDB client shared by every collection. It must be
+// called once before any collection is used. The driver connects lazily,
+// so this only fails on an invalid URI, not on an unreachable server.
+func Connect(ctx context.Context, uri string) error {
+	m, err := store.NewMongo(ctx, options.Client().
+		ApplyURI(uri).
+		SetMonitor(chainCommandMonitors(metrics.CommandMonitor(), tracing.CommandMonitor())).
+		SetPoolMonitor(metrics.PoolMonitor()), "testing")
+	if err != nil {
+		return err
+	}
+	mongoStore, Store = m, m
+	return nil
+}
+
+// UseStore makes resolvers use s instead of MongoDB, e.g. a store.Memory
+// in tests. Migrations only apply if s is a *store.Mongo.
+func UseStore(s store.Store) {
+	m, _ := s.(*store.Mongo)
+	mongoStore, Store = m, s
+}
+
+// EnableReadCache serves book and review listings from backend for ttl.
+// Writes through the store invalidate them.
+func EnableReadCache(backend cache.Cache, ttl time.Duration) {
+	Store = store.WithCache(Store, backend, ttl, "books", "reviews")
+}
+
+// Disconnect closes the store's connections once in-flight operations have
+// finished or ctx is done.
+func Disconnect(ctx context.Context) error {
+	if Store == nil {
+		return nil
+	}
+	return Store.Close(ctx)
+}
+
+func database() *mongo.Database {
+	return mongoStore.Database()
+}
+
+func collection(name string) store.Collection {
+	return Store.Collection(name)
+}
+
+// Ping checks that the store is reachable.
+func Ping(ctx context.Context) error {
+	return Store.Ping(ctx)
+}
+
+// chainCommandMonitors fans command events out to several monitors, since
+// the driver accepts only one.
+func chainCommandMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
+	}
+}
+
+// convertDates turns stored BSON dates into time.Time so graphql.DateTime
+// can serialize them.
+func convertDates(doc bson.M) bson.M {
+	for k, v := range doc {
+		if dt, ok := v.(primitive.DateTime); ok {
+			doc[k] = dt.Time()
+		}
+	}
+	return doc
+}
@@ -0,0 +1,124 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grphqlserver/auth"
+	"grphqlserver/logging"
+	"grphqlserver/sso"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LinkOIDCUser finds the user for an OpenID Connect identity and returns a
+// token for them. An identity seen for the first time is linked to the
+// local account with the same email if both the provider and the local
+// account have verified it, or gets a new account.
+func LinkOIDCUser(ctx context.Context, claims sso.Claims) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	collection := UsersCollection()
+
+	if claims.Issuer == "" || claims.Subject == "" {
+		return "", errors.New("identity token has no subject")
+	}
+	identity := bson.M{"oidcIssuer": claims.Issuer, "oidcSubject": claims.Subject}
+
+	var user bson.M
+	err := collection.FindOne(ctx, identity).Decode(&user)
+	if err == nil {
+		if isDisabled(user) {
+			return "", errAccountDisabled
+		}
+		return auth.GenerateToken(user["_id"].(primitive.ObjectID).Hex(), tokenVersion(user))
+	} else if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	email, emailOK := NormalizeEmail(claims.Email)
+
+	// Only link if both sides have verified the address. Otherwise anyone
+	// could take over an account by claiming its email at the provider, or
+	// pre-register the victim's address locally and wait for them to sign
+	// in.
+	if emailOK && claims.EmailVerified {
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"email": email, "emailVerified": true, "oidcSubject": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{
+				"oidcIssuer":  claims.Issuer,
+				"oidcSubject": claims.Subject,
+			}},
+		).Decode(&user)
+		if err == nil {
+			if isDisabled(user) {
+				return "", errAccountDisabled
+			}
+			return auth.GenerateToken(user["_id"].(primitive.ObjectID).Hex(), tokenVersion(user))
+		} else if err != mongo.ErrNoDocuments {
+			return "", err
+		}
+	}
+
+	username, err := availableUsername(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+
+	newUser := bson.M{
+		"userName":      username,
+		"displayName":   claims.Name,
+		"emailVerified": emailOK && claims.EmailVerified,
+		"role":          auth.RoleUser,
+		"oidcIssuer":    claims.Issuer,
+		"oidcSubject":   claims.Subject,
+	}
+	if emailOK {
+		newUser["email"] = email
+	}
+
+	id, err := collection.InsertOne(ctx, newUser)
+	if mongo.IsDuplicateKeyError(err) && emailOK {
+		// A local account holds the address without having verified it,
+		// or is linked to another identity. Keep the accounts apart.
+		delete(newUser, "email")
+		newUser["emailVerified"] = false
+		id, err = collection.InsertOne(ctx, newUser)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("error creating OIDC user", "error", err)
+		return "", err
+	}
+
+	return auth.GenerateToken(id.InsertedID.(primitive.ObjectID).Hex(), 0)
+}
+
+// availableUsername derives a username from the identity, adding a number
+// if it is already taken.
+func availableUsername(ctx context.Context, claims sso.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	for i := 0; i < 100; i++ {
+		name := base
+		if i > 0 {
+			name = fmt.Sprintf("%s%d", base, i+1)
+		}
+		err := UsersCollection().FindOne(ctx, bson.M{"userName": name}).Err()
+		if err == mongo.ErrNoDocuments {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("could not find a free username")
+}
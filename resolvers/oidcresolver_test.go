@@ -0,0 +1,80 @@
+package resolvers
+
+import (
+	"context"
+	"grphqlserver/auth"
+	"grphqlserver/sso"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLinkOIDCUser(t *testing.T) {
+	saved, savedMongo := Store, mongoStore
+	defer func() { Store, mongoStore = saved, savedMongo }()
+
+	tests := []struct {
+		name     string
+		verified bool
+		linked   bool
+	}{
+		{"verified local account is linked", true, true},
+		{"unverified local account is left alone", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			UseStore(NewMemoryStore())
+			ctx := context.Background()
+			res, err := UsersCollection().InsertOne(ctx, bson.M{
+				"userName":      "victim",
+				"email":         "victim@example.com",
+				"emailVerified": tt.verified,
+				"password":      "local password hash",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			localID := res.InsertedID.(primitive.ObjectID)
+
+			token, err := LinkOIDCUser(ctx, sso.Claims{
+				Issuer:        "https://idp.example.com",
+				Subject:       "subject-1",
+				Email:         "Victim@example.com",
+				EmailVerified: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID, _, err := auth.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked := userID == localID.Hex(); linked != tt.linked {
+				t.Errorf("signed in as the local account: %v, want %v", linked, tt.linked)
+			}
+
+			var local bson.M
+			if err := UsersCollection().FindOne(ctx, bson.M{"_id": localID}).Decode(&local); err != nil {
+				t.Fatal(err)
+			}
+			if _, linked := local["oidcSubject"]; linked != tt.linked {
+				t.Errorf("local account has an OIDC subject: %v, want %v", linked, tt.linked)
+			}
+			if local["emailVerified"] != tt.verified {
+				t.Errorf("local account's emailVerified = %v, want %v", local["emailVerified"], tt.verified)
+			}
+
+			if !tt.linked {
+				var created bson.M
+				id, _ := primitive.ObjectIDFromHex(userID)
+				if err := UsersCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&created); err != nil {
+					t.Fatal(err)
+				}
+				if created["email"] != nil || created["emailVerified"] != false {
+					t.Errorf("separate account = %v, want it without the contested email", created)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package resolvers
+
+import (
+	"fmt"
+	"grphqlserver/apperr"
+	"grphqlserver/auth"
+	"grphqlserver/logging"
+	"grphqlserver/mailer"
+	"grphqlserver/store"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const passwordResetTTL = time.Hour
+
+// Mailer is used for every email the service sends. main replaces it with
+// the configured implementation.
+var Mailer mailer.Mailer = &mailer.MemoryMailer{}
+
+func PasswordResetsCollection() store.Collection {
+	return collection("password_resets")
+}
+
+func RequestPasswordResetResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	arg, _ := p.Args["email"].(string)
+	email, ok := NormalizeEmail(arg)
+	if !ok {
+		return nil, apperr.NewBadUserInput("a valid email address is required")
+	}
+
+	// Always report success so the mutation can't be used to find out
+	// which addresses are registered. Failures past the lookup only
+	// happen for registered addresses, so they are logged, not returned.
+	var user bson.M
+	err := UsersCollection().FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	} else if err != nil {
+		logging.FromContext(p.Context).Error("error finding user for password reset", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		logging.FromContext(p.Context).Error("error generating password reset token", "error", err)
+		return true, nil
+	}
+
+	_, err = PasswordResetsCollection().InsertOne(ctx, bson.M{
+		"userID":    user["_id"],
+		"tokenHash": hash,
+		"expiresAt": time.Now().Add(passwordResetTTL),
+		"createdAt": time.Now(),
+	})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error storing password reset token", "error", err)
+		return true, nil
+	}
+
+	err = Mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use this token with the resetPassword mutation to choose a new password:\n\n%s\n\n"+
+			"The token expires in %s. If you didn't ask for a reset you can ignore this email.\n",
+			token, passwordResetTTL),
+	})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error sending password reset email", "error", err)
+		return true, nil
+	}
+
+	return true, nil
+}
+
+func ResetPasswordResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	token, _ := p.Args["token"].(string)
+	newPassword, _ := p.Args["newPassword"].(string)
+	if token == "" || newPassword == "" {
+		return nil, apperr.NewBadUserInput("token and new password are required")
+	}
+
+	filter := bson.M{
+		"tokenHash": auth.HashOpaqueToken(token),
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	var reset bson.M
+	err := PasswordResetsCollection().FindOne(ctx, filter).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return nil, apperr.NewBadUserInput("invalid or expired reset token")
+	} else if err != nil {
+		logging.FromContext(p.Context).Error("error finding password reset token", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	userID, ok := reset["userID"].(primitive.ObjectID)
+	if !ok {
+		return nil, apperr.NewBadUserInput("invalid or expired reset token")
+	}
+
+	var user bson.M
+	err = UsersCollection().FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		return nil, apperr.NewNotFound("user not found")
+	}
+
+	// Check the policy before claiming the token so a rejected password
+	// doesn't use it up.
+	username, _ := user["userName"].(string)
+	if err := auth.Policy.Validate(username, newPassword); err != nil {
+		return nil, apperr.Wrap(apperr.BadUserInput, err)
+	}
+
+	// Claim the token atomically so it can only be used once.
+	filter["_id"] = reset["_id"]
+	err = PasswordResetsCollection().FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"usedAt": time.Now()}}).Err()
+	if err == mongo.ErrNoDocuments {
+		return nil, apperr.NewBadUserInput("invalid or expired reset token")
+	} else if err != nil {
+		logging.FromContext(p.Context).Error("error claiming password reset token", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	hashedPassword, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+
+	res, err := UsersCollection().UpdateOne(ctx, bson.M{"_id": userID},
+		bson.M{
+			"$set": bson.M{"password": hashedPassword},
+			"$inc": bson.M{"tokenVersion": 1},
+		})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error updating password", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+	if res.MatchedCount == 0 {
+		return nil, apperr.NewNotFound("user not found")
+	}
+
+	// Any other outstanding tokens for this user are no longer needed.
+	_, err = PasswordResetsCollection().DeleteMany(ctx, bson.M{
+		"userID": userID,
+		"usedAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error discarding password reset tokens", "error", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,39 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"grphqlserver/mailer"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, mailer.Message) error {
+	return errors.New("smtp: connection refused")
+}
+
+func TestRequestPasswordResetHidesMailerFailures(t *testing.T) {
+	saved, savedMongo, savedMailer := Store, mongoStore, Mailer
+	defer func() { Store, mongoStore, Mailer = saved, savedMongo, savedMailer }()
+	UseStore(NewMemoryStore())
+	Mailer = failingMailer{}
+
+	ctx := context.Background()
+	if _, err := UsersCollection().InsertOne(ctx, bson.M{"userName": "alice", "email": "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		got, err := RequestPasswordResetResolver(graphql.ResolveParams{
+			Context: ctx,
+			Args:    map[string]interface{}{"email": email},
+		})
+		if got != true || err != nil {
+			t.Errorf("%s: %v, %v, want true whether or not the address is registered", email, got, err)
+		}
+	}
+}
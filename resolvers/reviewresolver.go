@@ -1,173 +1,175 @@
 package resolvers
 
 import (
-	"context"
-	"errors"
-	"log"
+	"fmt"
+	"grphqlserver/apperr"
+	"grphqlserver/logging"
+	"grphqlserver/policy"
+	"grphqlserver/store"
 	"time"
 
 	"github.com/graphql-go/graphql"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func ReviewCollection() *mongo.Collection {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://mongo:27017"))
+func ReviewCollection() store.Collection {
+	return collection("reviews")
+}
 
-	if err != nil {
-		log.Panic("Error when connecting to mongodb", err)
+// reviewResource describes a stored review for the policy checks.
+func reviewResource(review bson.M) policy.Resource {
+	r := policy.Resource{Kind: policy.Review}
+	if owner, ok := review["userID"].(primitive.ObjectID); ok {
+		r.OwnerID = owner.Hex()
 	}
-
-	collection := client.Database("testing").Collection("reviews")
-
-	return collection
+	return r
 }
 
-func ReviewResolver(_ graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func ReviewResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
 	collection := ReviewCollection()
 	result, err := collection.Find(ctx, bson.D{})
 	if err != nil {
-		log.Print("Error in finding review", err)
-		return nil, err
+		logging.FromContext(p.Context).Error("error finding review", "error", err)
+		return nil, apperr.NewInternal(err)
 	}
 	defer result.Close(ctx)
 
 	var r []bson.M
 	err = result.All(ctx, &r)
 	if err != nil {
-		log.Print("Error in reading review from cursor", err)
+		logging.FromContext(p.Context).Error("error reading review from cursor", "error", err)
 	}
 	return r, nil
 }
 
 func AddReviewResolver(p graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx := p.Context
 	collection := ReviewCollection()
 
-	userIDStr, ok := p.Context.Value("userID").(string)
-	if !ok {
-		log.Println("Missing userID in context")
-		return nil, errors.New("unauthorized: missing user ID")
+	userID, err := currentUserID(p)
+	if err != nil {
+		return nil, err
 	}
-	log.Println("Extracted userID:", userIDStr)
 
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		log.Println("Invalid userID format:", userIDStr)
-		return nil, errors.New("invalid user ID format")
+	if err := policy.Check(currentActor(p), policy.Create, policy.Resource{Kind: policy.Review}); err != nil {
+		return nil, err
+	}
+
+	if err := requireVerifiedEmail(ctx, userID); err != nil {
+		return nil, err
 	}
 
 	input, ok := p.Args["input"].(map[string]interface{})
 	if !ok {
-		return nil, errors.New("invalid input data")
+		return nil, apperr.NewBadUserInput("invalid input data")
 	}
 
 	input["userID"] = userID
 
+	bookID, hasBook := input["bookID"].(primitive.ObjectID)
+	if hasBook {
+		if err := BooksCollection().FindOne(ctx, bson.M{"_id": bookID}).Err(); err != nil {
+			return nil, apperr.NewNotFound("book not found")
+		}
+	}
+
 	if _, exists := input["date"]; !exists {
 		input["date"] = time.Now()
 	}
 
 	res, err := collection.InsertOne(ctx, input)
 	if err != nil {
-		log.Print("Error inserting review:", err)
-		return nil, err
+		logging.FromContext(p.Context).Error("error inserting review", "error", err)
+		return nil, apperr.NewInternal(err)
 	}
 	input["_id"] = res.InsertedID
+
+	// A reviewed book has been read.
+	if hasBook {
+		if _, err := shelve(ctx, userID, bookID, ShelfRead, time.Now()); err != nil {
+			logging.FromContext(p.Context).Error("error shelving reviewed book", "error", err)
+		}
+	}
 	return input, nil
 }
 
 func DeleteReviewResolver(p graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx := p.Context
 	collection := ReviewCollection()
 
-	userID, ok := p.Context.Value("userID").(string)
-	if !ok {
-		return nil, errors.New("unauthorized: missing user ID")
-	}
-
 	id, ok := p.Args["_id"].(primitive.ObjectID)
 	if !ok {
-		return nil, errors.New("missing review ID")
+		return nil, apperr.NewBadUserInput("missing review ID")
 	}
 
 	var review bson.M
 	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&review)
 	if err != nil {
-		return nil, errors.New("review not found")
+		return nil, apperr.NewNotFound("review not found")
 	}
 
-	if review["userID"] != userID {
-		return nil, errors.New("unauthorized: you can only delete your own reviews")
+	if err := policy.Check(currentActor(p), policy.Delete, reviewResource(review)); err != nil {
+		return nil, err
 	}
 
 	result, err := collection.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
-		log.Print("Error deleting review:", err)
-		return nil, err
+		logging.FromContext(p.Context).Error("error deleting review", "error", err)
+		return nil, apperr.NewInternal(err)
 	}
 
 	if result.DeletedCount == 0 {
-		return nil, errors.New("review not found")
+		return nil, apperr.NewNotFound("review not found")
 	}
 	return true, nil
 
 }
 
 func UpdateReviewResolver(p graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx := p.Context
 	collection := ReviewCollection()
 
-	userID, ok := p.Context.Value("userID").(string)
-	if !ok {
-		return nil, errors.New("unauthorized: missing user ID")
-	}
-
 	id, ok := p.Args["_id"].(primitive.ObjectID)
 	if !ok {
-		return nil, errors.New("missing or invalid review ID")
+		return nil, apperr.NewBadUserInput("missing or invalid review ID")
 	}
 
 	var review bson.M
 	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&review)
 	if err != nil {
-		return nil, errors.New("review not found")
+		return nil, apperr.NewNotFound("review not found")
 	}
 
-	if review["userID"] != userID {
-		return nil, errors.New("unauthorized: you can only edit your own reviews")
+	if err := policy.Check(currentActor(p), policy.Update, reviewResource(review)); err != nil {
+		return nil, err
 	}
 
 	input, ok := p.Args["input"].(map[string]interface{})
 	if !ok {
-		return nil, errors.New("invalid input data")
+		return nil, apperr.NewBadUserInput("invalid input data")
 	}
 
+	// The author of a review can't be changed.
+	delete(input, "userID")
+
 	update := bson.M{"$set": input}
 
 	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
 	if err != nil {
-		log.Print("Error updating review:", err)
-		return nil, err
+		logging.FromContext(p.Context).Error("error updating review", "error", err)
+		return nil, apperr.NewInternal(err)
 	}
 
 	if result.MatchedCount == 0 {
-		return nil, errors.New("review not found")
+		return nil, apperr.NewNotFound("review not found")
 	}
 
 	var updatedReview bson.M
 	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&updatedReview)
 	if err != nil {
-		return nil, err
+		return nil, apperr.NewInternal(err)
 	}
 
 	return updatedReview, nil
@@ -175,8 +177,7 @@ func UpdateReviewResolver(p graphql.ResolveParams) (interface{}, error) {
 }
 
 func FindReviewsResolver(p graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx := p.Context
 	collection := ReviewCollection()
 	filter := bson.M{}
 
@@ -201,19 +202,19 @@ func FindReviewsResolver(p graphql.ResolveParams) (interface{}, error) {
 		booksCollection := BooksCollection()
 		cursor, err := booksCollection.Find(ctx, bookFilter)
 		if err != nil {
-			log.Println("Error finding books:", err)
-			return nil, err
+			logging.FromContext(p.Context).Error("error finding books", "error", err)
+			return nil, apperr.NewInternal(err)
 		}
 		defer cursor.Close(ctx)
 
 		var books []bson.M
 		if err = cursor.All(ctx, &books); err != nil {
-			log.Println("Error reading books from cursor:", err)
-			return nil, err
+			logging.FromContext(p.Context).Error("error reading books from cursor", "error", err)
+			return nil, apperr.NewInternal(err)
 		}
 
 		if len(books) == 0 {
-			return nil, errors.New("no books found with the given title or author")
+			return nil, apperr.NewNotFound("no books found with the given title or author")
 		}
 
 		var bookIDs []primitive.ObjectID
@@ -229,15 +230,15 @@ func FindReviewsResolver(p graphql.ResolveParams) (interface{}, error) {
 
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
-		log.Println("Error finding reviews:", err)
-		return nil, err
+		logging.FromContext(p.Context).Error("error finding reviews", "error", err)
+		return nil, apperr.NewInternal(err)
 	}
 	defer cursor.Close(ctx)
 
 	var reviews []bson.M
 	if err = cursor.All(ctx, &reviews); err != nil {
-		log.Println("Error reading reviews from cursor:", err)
-		return nil, err
+		logging.FromContext(p.Context).Error("error reading reviews from cursor", "error", err)
+		return nil, apperr.NewInternal(err)
 	}
 
 	for i, review := range reviews {
@@ -245,13 +246,14 @@ func FindReviewsResolver(p graphql.ResolveParams) (interface{}, error) {
 			if dt, isDate := date.(primitive.DateTime); isDate {
 				reviews[i]["date"] = dt.Time()
 			} else {
-				log.Printf("Review %d has an invalid date format: %T\n", i, date)
+				logging.FromContext(p.Context).Warn("review has an invalid date format",
+					"review_id", review["_id"], "type", fmt.Sprintf("%T", date))
 			}
 		}
 	}
 
 	if len(reviews) == 0 {
-		return nil, errors.New("reviews not found")
+		return nil, apperr.NewNotFound("reviews not found")
 	}
 
 	return reviews, nil
@@ -0,0 +1,356 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"grphqlserver/apperr"
+	"grphqlserver/logging"
+	"grphqlserver/policy"
+	"grphqlserver/store"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The built-in shelves track reading status. A book is on at most one of
+// them per user; custom shelves have no such limit.
+const (
+	ShelfWantToRead = "WANT_TO_READ"
+	ShelfReading    = "READING"
+	ShelfRead       = "READ"
+)
+
+// StatusShelves are the built-in shelves in reading order.
+var StatusShelves = []string{ShelfWantToRead, ShelfReading, ShelfRead}
+
+const maxShelfNameLength = 64
+
+// ShelvesCollection holds one document per book on a user's shelf:
+// userID, bookID, shelf, addedAt, builtIn for the built-in shelves and,
+// once it changed shelves, movedAt.
+func ShelvesCollection() store.Collection {
+	return collection("shelves")
+}
+
+func isStatusShelf(name string) bool {
+	return contains(StatusShelves, name)
+}
+
+// ShelfName checks the name of a shelf and returns it trimmed. Custom
+// shelves can't take a built-in shelf's name in any case.
+func ShelfName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if isStatusShelf(name) {
+		return name, nil
+	}
+	for _, status := range StatusShelves {
+		if strings.EqualFold(name, status) {
+			return "", apperr.NewBadUserInput(fmt.Sprintf("shelf name %q is reserved; use %s", name, status))
+		}
+	}
+	if name == "" {
+		return "", apperr.NewBadUserInput("shelf name is required")
+	}
+	if utf8.RuneCountInString(name) > maxShelfNameLength {
+		return "", apperr.NewBadUserInput(fmt.Sprintf("shelf name must be at most %d characters", maxShelfNameLength))
+	}
+	return name, nil
+}
+
+// shelfArgs reads the current user and the bookID argument, and checks
+// that the user may perform action on their shelves.
+func shelfArgs(p graphql.ResolveParams, action policy.Action) (userID, bookID primitive.ObjectID, err error) {
+	userID, err = currentUserID(p)
+	if err != nil {
+		return userID, bookID, err
+	}
+	if err := policy.Check(currentActor(p), action, policy.Resource{Kind: policy.Shelf, OwnerID: userID.Hex()}); err != nil {
+		return userID, bookID, err
+	}
+	bookID, ok := p.Args["bookID"].(primitive.ObjectID)
+	if !ok || bookID.IsZero() {
+		return userID, bookID, apperr.NewBadUserInput("missing or invalid book ID")
+	}
+	return userID, bookID, nil
+}
+
+// ShelfEntry returns a new entry for a book on a shelf. Entries on the
+// built-in shelves are marked builtIn, which a unique index allows once per
+// user and book.
+func ShelfEntry(userID, bookID primitive.ObjectID, shelf string, addedAt time.Time) bson.M {
+	entry := bson.M{"userID": userID, "bookID": bookID, "shelf": shelf, "addedAt": addedAt}
+	if isStatusShelf(shelf) {
+		entry["builtIn"] = true
+	}
+	return entry
+}
+
+// shelve puts a book on one of the user's shelves and returns the entry.
+// A book on another built-in shelf is moved rather than added, and a book
+// already on the shelf stays as it is.
+func shelve(ctx context.Context, userID, bookID primitive.ObjectID, shelf string, now time.Time) (bson.M, error) {
+	collection := ShelvesCollection()
+	onShelf := bson.M{"userID": userID, "bookID": bookID, "shelf": shelf}
+
+	// A concurrent request may shelve the book between the lookups and the
+	// insert. The unique indexes reject the second insert, and looking
+	// again then finds the entry to return or move.
+	for attempt := 0; ; attempt++ {
+		var entry bson.M
+		err := collection.FindOne(ctx, onShelf).Decode(&entry)
+		if err == nil {
+			return entry, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+
+		if isStatusShelf(shelf) {
+			err := collection.FindOneAndUpdate(ctx,
+				bson.M{"userID": userID, "bookID": bookID, "builtIn": true},
+				bson.M{"$set": bson.M{"shelf": shelf, "movedAt": now}},
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&entry)
+			if err == nil {
+				return entry, nil
+			}
+			if err != mongo.ErrNoDocuments {
+				return nil, err
+			}
+		}
+
+		entry = ShelfEntry(userID, bookID, shelf, now)
+		res, err := collection.InsertOne(ctx, entry)
+		if mongo.IsDuplicateKeyError(err) && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry["_id"] = res.InsertedID
+		return entry, nil
+	}
+}
+
+func AddToShelfResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	userID, bookID, err := shelfArgs(p, policy.Create)
+	if err != nil {
+		return nil, err
+	}
+	arg, _ := p.Args["shelf"].(string)
+	shelf, err := ShelfName(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	var book bson.M
+	if err := BooksCollection().FindOne(ctx, bson.M{"_id": bookID}).Decode(&book); err != nil {
+		return nil, apperr.NewNotFound("book not found")
+	}
+
+	entry, err := shelve(ctx, userID, bookID, shelf, time.Now())
+	if err != nil {
+		logging.FromContext(p.Context).Error("error shelving book", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+	entry["book"] = book
+	return convertDates(entry), nil
+}
+
+func MoveBookResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+	collection := ShelvesCollection()
+
+	userID, bookID, err := shelfArgs(p, policy.Update)
+	if err != nil {
+		return nil, err
+	}
+	fromArg, _ := p.Args["from"].(string)
+	toArg, _ := p.Args["to"].(string)
+	from, err := ShelfName(fromArg)
+	if err != nil {
+		return nil, err
+	}
+	to, err := ShelfName(toArg)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry bson.M
+	err = collection.FindOne(ctx, bson.M{"userID": userID, "bookID": bookID, "shelf": from}).Decode(&entry)
+	if err != nil {
+		return nil, apperr.NewNotFound(fmt.Sprintf("book is not on shelf %q", from))
+	}
+
+	if from != to {
+		// The target shelf may already hold the book, and a book leaves
+		// its other built-in shelf when moved onto one.
+		others := bson.M{"userID": userID, "bookID": bookID, "shelf": to}
+		if isStatusShelf(to) {
+			others["shelf"] = bson.M{"$in": StatusShelves, "$ne": from}
+		}
+		if _, err := collection.DeleteMany(ctx, others); err != nil {
+			logging.FromContext(p.Context).Error("error clearing target shelf", "error", err)
+			return nil, apperr.NewInternal(err)
+		}
+
+		set := bson.M{"shelf": to, "movedAt": time.Now()}
+		update := bson.M{"$set": set}
+		if isStatusShelf(to) {
+			set["builtIn"] = true
+		} else {
+			update["$unset"] = bson.M{"builtIn": ""}
+		}
+		err = collection.FindOneAndUpdate(ctx, bson.M{"_id": entry["_id"]}, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&entry)
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, apperr.NewConflict("book was shelved by another request; try again")
+		}
+		if err != nil {
+			logging.FromContext(p.Context).Error("error moving book", "error", err)
+			return nil, apperr.NewInternal(err)
+		}
+	}
+
+	var book bson.M
+	if err := BooksCollection().FindOne(ctx, bson.M{"_id": bookID}).Decode(&book); err == nil {
+		entry["book"] = book
+	}
+	return convertDates(entry), nil
+}
+
+func RemoveFromShelfResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	userID, bookID, err := shelfArgs(p, policy.Delete)
+	if err != nil {
+		return nil, err
+	}
+	arg, _ := p.Args["shelf"].(string)
+	shelf, err := ShelfName(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ShelvesCollection().DeleteOne(ctx, bson.M{"userID": userID, "bookID": bookID, "shelf": shelf})
+	if err != nil {
+		logging.FromContext(p.Context).Error("error removing book from shelf", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+	if res.DeletedCount == 0 {
+		return nil, apperr.NewNotFound(fmt.Sprintf("book is not on shelf %q", shelf))
+	}
+	return true, nil
+}
+
+// UserShelvesResolver lists a user's shelves: the built-in ones, even when
+// empty, then custom shelves by name. Books are listed in the order they
+// were added.
+func UserShelvesResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	user, _ := p.Source.(bson.M)
+	userID, ok := user["_id"].(primitive.ObjectID)
+	if !ok {
+		return nil, nil
+	}
+	if err := policy.Check(currentActor(p), policy.Read, policy.Resource{Kind: policy.Shelf, OwnerID: userID.Hex()}); err != nil {
+		return nil, err
+	}
+
+	cursor, err := ShelvesCollection().Find(ctx, bson.M{"userID": userID},
+		options.Find().SetSort(bson.D{{Key: "addedAt", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		logging.FromContext(p.Context).Error("error finding shelves", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+	defer cursor.Close(ctx)
+
+	var entries []bson.M
+	if err := cursor.All(ctx, &entries); err != nil {
+		logging.FromContext(p.Context).Error("error reading shelves from cursor", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	books, err := booksByID(ctx, entries)
+	if err != nil {
+		logging.FromContext(p.Context).Error("error finding shelved books", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	byShelf := map[string][]bson.M{}
+	var custom []string
+	for _, entry := range entries {
+		name, _ := entry["shelf"].(string)
+		if _, seen := byShelf[name]; !seen && !isStatusShelf(name) {
+			custom = append(custom, name)
+		}
+		entry["book"] = books[entry["bookID"]]
+		byShelf[name] = append(byShelf[name], convertDates(entry))
+	}
+	sort.Strings(custom)
+
+	var shelves []bson.M
+	for _, name := range StatusShelves {
+		shelves = append(shelves, bson.M{"name": name, "status": name, "books": byShelf[name]})
+	}
+	for _, name := range custom {
+		shelves = append(shelves, bson.M{"name": name, "books": byShelf[name]})
+	}
+	return shelves, nil
+}
+
+// booksByID loads the books that entries refer to.
+func booksByID(ctx context.Context, entries []bson.M) (map[interface{}]bson.M, error) {
+	books := map[interface{}]bson.M{}
+	if len(entries) == 0 {
+		return books, nil
+	}
+	ids := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry["bookID"])
+	}
+	cursor, err := BooksCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []bson.M
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, book := range found {
+		books[book["_id"]] = book
+	}
+	return books, nil
+}
+
+// BookShelfCountsResolver counts the users who have a book on each of the
+// built-in shelves. The counts of all the books in a list are loaded
+// together; see Loaders.
+func BookShelfCountsResolver(p graphql.ResolveParams) (interface{}, error) {
+	book, _ := p.Source.(bson.M)
+	bookID, ok := book["_id"].(primitive.ObjectID)
+	if !ok {
+		return nil, nil
+	}
+
+	load := shelfCountsFor(p.Context).load(bookID)
+	return func() (interface{}, error) {
+		counts, err := load()
+		if err != nil {
+			logging.FromContext(p.Context).Error("error counting shelved books", "error", err)
+			return nil, apperr.NewInternal(err)
+		}
+		return counts, nil
+	}, nil
+}
@@ -0,0 +1,167 @@
+package resolvers
+
+import (
+	"context"
+	"grphqlserver/store"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestShelveRecordsTimestamps(t *testing.T) {
+	saved, savedMongo := Store, mongoStore
+	defer func() { Store, mongoStore = saved, savedMongo }()
+	UseStore(NewMemoryStore())
+
+	ctx := context.Background()
+	userID, bookID := primitive.NewObjectID(), primitive.NewObjectID()
+	added := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	started := added.Add(48 * time.Hour)
+
+	first, err := shelve(ctx, userID, bookID, ShelfWantToRead, added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := shelve(ctx, userID, bookID, ShelfReading, started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := shelve(ctx, userID, bookID, ShelfReading, started.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shelve(ctx, userID, bookID, "Gifts", started); err != nil {
+		t.Fatal(err)
+	}
+
+	convertDates(moved)
+	convertDates(again)
+	if moved["_id"] != first["_id"] || moved["shelf"] != ShelfReading {
+		t.Errorf("moving to another built-in shelf gave %v, want entry %v on %s", moved, first["_id"], ShelfReading)
+	}
+	if at, _ := moved["addedAt"].(time.Time); !at.Equal(added) {
+		t.Errorf("addedAt = %v after a move, want %v", moved["addedAt"], added)
+	}
+	if at, _ := again["movedAt"].(time.Time); !at.Equal(started) {
+		t.Errorf("movedAt = %v after shelving again, want %v", again["movedAt"], started)
+	}
+
+	n, err := ShelvesCollection().CountDocuments(ctx, bson.M{"userID": userID})
+	if err != nil || n != 2 {
+		t.Errorf("%d entries (%v), want one built-in and one custom", n, err)
+	}
+}
+
+func TestShelveConcurrently(t *testing.T) {
+	saved, savedMongo := Store, mongoStore
+	defer func() { Store, mongoStore = saved, savedMongo }()
+	UseStore(NewMemoryStore())
+
+	ctx := context.Background()
+	userID, bookID := primitive.NewObjectID(), primitive.NewObjectID()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(shelf string) {
+			defer wg.Done()
+			if _, err := shelve(ctx, userID, bookID, shelf, time.Now()); err != nil {
+				t.Error(err)
+			}
+		}(StatusShelves[i%len(StatusShelves)])
+	}
+	wg.Wait()
+
+	n, err := ShelvesCollection().CountDocuments(ctx, bson.M{"userID": userID, "bookID": bookID})
+	if err != nil || n != 1 {
+		t.Errorf("%d entries (%v), want the book on one built-in shelf", n, err)
+	}
+}
+
+// aggregateCounter counts the aggregations run on its collections.
+type aggregateCounter struct {
+	store.Store
+	n atomic.Int32
+}
+
+func (s *aggregateCounter) Collection(name string) store.Collection {
+	return countedCollection{s.Store.Collection(name), &s.n}
+}
+
+type countedCollection struct {
+	store.Collection
+	n *atomic.Int32
+}
+
+func (c countedCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	c.n.Add(1)
+	return c.Collection.Aggregate(ctx, pipeline, opts...)
+}
+
+func TestShelfCountsLoadedTogether(t *testing.T) {
+	saved, savedMongo := Store, mongoStore
+	defer func() { Store, mongoStore = saved, savedMongo }()
+	counter := &aggregateCounter{Store: NewMemoryStore()}
+	UseStore(counter)
+
+	ctx := context.Background()
+	gopl, sicp, unread := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	for _, s := range []struct {
+		book  primitive.ObjectID
+		shelf string
+	}{{gopl, ShelfRead}, {gopl, ShelfRead}, {gopl, ShelfReading}, {gopl, "Gifts"}, {sicp, ShelfWantToRead}} {
+		if _, err := shelve(ctx, primitive.NewObjectID(), s.book, s.shelf, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loader := shelfCountsFor(ctx)
+	thunks := map[primitive.ObjectID]func() (interface{}, error){}
+	for _, id := range []primitive.ObjectID{gopl, sicp, unread} {
+		thunks[id] = loader.load(id)
+	}
+	want := map[primitive.ObjectID]bson.M{
+		gopl:   {"wantToRead": int64(0), "reading": int64(1), "read": int64(2)},
+		sicp:   {"wantToRead": int64(1), "reading": int64(0), "read": int64(0)},
+		unread: {"wantToRead": int64(0), "reading": int64(0), "read": int64(0)},
+	}
+	for id, thunk := range thunks {
+		got, err := thunk()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, _ := got.(bson.M)
+		for field, n := range want[id] {
+			if counts[field] != n {
+				t.Errorf("%s: %s = %v, want %v", id.Hex(), field, counts[field], n)
+			}
+		}
+	}
+	if n := counter.n.Load(); n != 1 {
+		t.Errorf("%d aggregations for three books, want 1", n)
+	}
+}
+
+func TestShelfName(t *testing.T) {
+	tests := map[string]string{
+		"READ":         "READ",
+		"  To lend ":   "To lend",
+		"read":         "",
+		"Want_To_Read": "",
+		"   ":          "",
+	}
+	for input, want := range tests {
+		got, err := ShelfName(input)
+		if want == "" && err == nil {
+			t.Errorf("ShelfName(%q) = %q, want an error", input, got)
+		}
+		if want != "" && (err != nil || got != want) {
+			t.Errorf("ShelfName(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+}
@@ -3,121 +3,279 @@ package resolvers
 import (
 	"context"
 	"errors"
+	"grphqlserver/apperr"
 	"grphqlserver/auth"
-	"log"
-	"time"
+	"grphqlserver/lockout"
+	"grphqlserver/logging"
+	"grphqlserver/store"
+	"sync"
 
 	"github.com/graphql-go/graphql"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func UsersCollection() *mongo.Collection {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://mongo:27017"))
-
-	if err != nil {
-		log.Panic("Error when connecting to mongodb", err)
-	}
-
-	collection := client.Database("testing").Collection("users")
-
-	return collection
+func UsersCollection() store.Collection {
+	return collection("users")
 }
 
-func UserResolver(_ graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func UserResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
 	collection := UsersCollection()
-	result, err := collection.Find(ctx, bson.D{})
+	result, err := collection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.M{"password": 0}))
 	if err != nil {
-		log.Print("Error in finding user", err)
-		return nil, err
+		logging.FromContext(p.Context).Error("error finding user", "error", err)
+		return nil, apperr.NewInternal(err)
 	}
 	defer result.Close(ctx)
 
 	var r []bson.M
 	err = result.All(ctx, &r)
 	if err != nil {
-		log.Print("Error in reading users from cursor", err)
+		logging.FromContext(p.Context).Error("error reading users from cursor", "error", err)
 	}
 	return r, nil
 }
 
 func RegisterUserResolver(p graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	collection := UsersCollection()
+	ctx := p.Context
 
 	input, _ := p.Args["input"].(map[string]interface{})
 	username, _ := input["userName"].(string)
 	password, _ := input["password"].(string)
 	email, _ := input["email"].(string)
 
-	if username == "" {
-		return nil, errors.New("username cannot be empty")
+	id, err := CreateUser(ctx, NewUser{
+		UserName: username,
+		Email:    email,
+		Password: password,
+		Role:     auth.RoleUser,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	email, _ = NormalizeEmail(email)
+	err = sendEmailVerification(ctx, id, email)
+	if err != nil {
+		logging.FromContext(p.Context).Error("error sending verification email", "error", err)
+	}
+
+	token, err := auth.GenerateToken(id.Hex(), 0)
+	if err != nil {
+		return nil, apperr.NewInternal(err)
+	}
+
+	return token, nil
+}
+
+// NewUser is an account with a password, created by registration or by an
+// operator.
+type NewUser struct {
+	UserName      string
+	Email         string
+	Password      string
+	Role          string
+	EmailVerified bool
+}
+
+// CreateUser validates and stores a new account and returns its ID.
+func CreateUser(ctx context.Context, u NewUser) (primitive.ObjectID, error) {
+	collection := UsersCollection()
+
+	if u.UserName == "" {
+		return primitive.NilObjectID, apperr.NewBadUserInput("username cannot be empty")
+	}
+
+	email, ok := NormalizeEmail(u.Email)
+	if !ok {
+		return primitive.NilObjectID, apperr.NewBadUserInput("a valid email address is required")
+	}
+
+	if !auth.ValidRole(u.Role) {
+		return primitive.NilObjectID, apperr.NewBadUserInput("unknown role " + u.Role)
+	}
+
+	if err := auth.Policy.Validate(u.UserName, u.Password); err != nil {
+		return primitive.NilObjectID, apperr.Wrap(apperr.BadUserInput, err)
 	}
 
 	var existingUser bson.M
-	err := collection.FindOne(ctx, bson.M{"userName": username}).Decode(&existingUser)
+	err := collection.FindOne(ctx, bson.M{"userName": u.UserName}).Decode(&existingUser)
 	if err == nil {
-		return nil, errors.New("username already exists")
+		return primitive.NilObjectID, apperr.NewConflict("username already exists")
 	} else if err != mongo.ErrNoDocuments {
-		return nil, err
+		return primitive.NilObjectID, apperr.NewInternal(err)
+	}
+
+	err = collection.FindOne(ctx, bson.M{"email": email}).Err()
+	if err == nil {
+		return primitive.NilObjectID, apperr.NewConflict("email already registered")
+	} else if err != mongo.ErrNoDocuments {
+		return primitive.NilObjectID, apperr.NewInternal(err)
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := auth.HashPassword(u.Password)
 	if err != nil {
-		return nil, err
+		return primitive.NilObjectID, apperr.NewInternal(err)
 	}
 
 	newUser := bson.M{
-		"userName": username,
-		"password": string(hashedPassword),
-		"email":    email,
+		"userName":      u.UserName,
+		"password":      hashedPassword,
+		"email":         email,
+		"emailVerified": u.EmailVerified,
+		"role":          u.Role,
 	}
 
 	id, err := collection.InsertOne(ctx, newUser)
-	if err != nil {
-		return nil, err
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, apperr.NewConflict("email already registered")
+	} else if err != nil {
+		return primitive.NilObjectID, apperr.NewInternal(err)
 	}
 
-	token, err := auth.GenerateToken(id.InsertedID.(primitive.ObjectID).Hex())
-	if err != nil {
-		return nil, err
-	}
+	return id.InsertedID.(primitive.ObjectID), nil
+}
 
-	return token, nil
+// LoginGuard throttles repeated login failures per account and per client
+// IP. main replaces it with one backed by the configured store.
+var LoginGuard = lockout.NewGuard(lockout.NewMemoryStore())
+
+var errInvalidCredentials = apperr.NewUnauthenticated("invalid username or password")
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash is verified against when the user doesn't exist so
+// that unknown usernames take as long to reject as wrong passwords.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = auth.HashPassword("dummy password")
+	})
+	return dummyHash
 }
 
 func LoginUserResolver(p graphql.ResolveParams) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx := p.Context
 	collection := UsersCollection()
 
 	input, _ := p.Args["input"].(map[string]interface{})
 	username, _ := input["userName"].(string)
 	password, _ := input["password"].(string)
 
+	accountKey := lockout.AccountKey(username)
+	ipKey := ""
+	if ip, ok := p.Context.Value("ClientIP").(string); ok && ip != "" {
+		ipKey = lockout.IPKey(ip)
+	}
+
+	if err := LoginGuard.Check(ctx, accountKey, lockout.AccountPolicy); err != nil {
+		return nil, throttleError(err)
+	}
+	if ipKey != "" {
+		if err := LoginGuard.Check(ctx, ipKey, lockout.IPPolicy); err != nil {
+			return nil, throttleError(err)
+		}
+	}
+
 	var user bson.M
 	err := collection.FindOne(ctx, bson.M{"userName": username}).Decode(&user)
-	if err != nil {
-		return nil, errors.New("user not found")
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, apperr.NewInternal(err)
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user["password"].(string)), []byte(password))
+	// Accounts created through single sign-on have no password and can't
+	// log in here.
+	hash := dummyPasswordHash()
+	stored, hasPassword := user["password"].(string)
+	if hasPassword && stored != "" {
+		hash = stored
+	} else {
+		hasPassword = false
+	}
+	match, stale, err := auth.VerifyPassword(hash, password)
 	if err != nil {
-		return nil, errors.New("invalid password")
+		logging.FromContext(p.Context).Error("error verifying password", "error", err)
+	}
+	if !match || !hasPassword {
+		if err := LoginGuard.Fail(ctx, accountKey, lockout.AccountPolicy); err != nil {
+			logging.FromContext(p.Context).Error("error recording failed login", "error", err)
+		}
+		if ipKey != "" {
+			if err := LoginGuard.Fail(ctx, ipKey, lockout.IPPolicy); err != nil {
+				logging.FromContext(p.Context).Error("error recording failed login", "error", err)
+			}
+		}
+		return nil, errInvalidCredentials
+	}
+
+	if err := LoginGuard.Reset(ctx, accountKey); err != nil {
+		logging.FromContext(p.Context).Error("error resetting failed logins", "error", err)
+	}
+
+	if isDisabled(user) {
+		return nil, errAccountDisabled
+	}
+
+	if stale {
+		rehashPassword(ctx, user["_id"], hash, password)
 	}
 
-	token, err := auth.GenerateToken(user["_id"].(primitive.ObjectID).Hex())
+	token, err := auth.GenerateToken(user["_id"].(primitive.ObjectID).Hex(), tokenVersion(user))
 	if err != nil {
-		return nil, err
+		return nil, apperr.NewInternal(err)
 	}
 
 	return token, nil
 }
+
+func throttleError(err error) error {
+	var locked *lockout.ErrLocked
+	if errors.As(err, &locked) {
+		return apperr.Wrap(apperr.RateLimited, err)
+	}
+	return apperr.NewInternal(err)
+}
+
+func UnlockUserResolver(p graphql.ResolveParams) (interface{}, error) {
+	ctx := p.Context
+
+	username, _ := p.Args["userName"].(string)
+	if username == "" {
+		return nil, apperr.NewBadUserInput("username cannot be empty")
+	}
+
+	if err := LoginGuard.Reset(ctx, lockout.AccountKey(username)); err != nil {
+		logging.FromContext(p.Context).Error("error unlocking user", "error", err)
+		return nil, apperr.NewInternal(err)
+	}
+
+	return true, nil
+}
+
+// rehashPassword replaces an outdated password hash after a successful
+// login. It only updates the document if the hash hasn't changed meanwhile.
+func rehashPassword(ctx context.Context, userID interface{}, oldHash, password string) {
+	newHash, err := auth.HashPassword(password)
+	if err != nil {
+		logging.FromContext(ctx).Error("error rehashing password", "error", err)
+		return
+	}
+	_, err = UsersCollection().UpdateOne(ctx,
+		bson.M{"_id": userID, "password": oldHash},
+		bson.M{"$set": bson.M{"password": newHash}})
+	if err != nil {
+		logging.FromContext(ctx).Error("error storing rehashed password", "error", err)
+	}
+}
+
+// LoginAttemptsCollection backs the Mongo lockout store, which manages its
+// own TTL index and so needs the MongoDB collection itself.
+func LoginAttemptsCollection() *mongo.Collection {
+	return database().Collection("login_attempts")
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"grphqlserver/httpcache"
+	"grphqlserver/metrics"
 	"grphqlserver/middleware"
+	"grphqlserver/policy"
 	"grphqlserver/resolvers"
+	"time"
 
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/graphql/language/ast"
@@ -48,6 +52,47 @@ var ObjectID = graphql.NewScalar(graphql.ScalarConfig{
 	},
 })
 
+var Email = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Email",
+	Description: "The `Email` scalar type represents an email address. Addresses are compared case-insensitively.",
+	Serialize: func(value interface{}) interface{} {
+		switch value := value.(type) {
+		case string:
+			return value
+		case *string:
+			return *value
+		default:
+			return nil
+		}
+	},
+	// ParseValue validates the address and normalises it to lower case.
+	ParseValue: func(value interface{}) interface{} {
+		switch value := value.(type) {
+		case string:
+			return parseEmail(value)
+		case *string:
+			return parseEmail(*value)
+		default:
+			return nil
+		}
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch valueAST := valueAST.(type) {
+		case *ast.StringValue:
+			return parseEmail(valueAST.Value)
+		}
+		return nil
+	},
+})
+
+func parseEmail(s string) interface{} {
+	email, ok := resolvers.NormalizeEmail(s)
+	if !ok {
+		return nil
+	}
+	return email
+}
+
 var User = graphql.NewObject(
 	graphql.ObjectConfig{
 		Name: "User",
@@ -58,12 +103,32 @@ var User = graphql.NewObject(
 			"userName": &graphql.Field{
 				Type: graphql.String,
 			},
+			// Only the owner and admins may see an email address. The
+			// password hash is never part of the type and is projected out
+			// by the resolvers.
 			"email": &graphql.Field{
+				Type:    Email,
+				Resolve: middleware.Auth(middleware.Can(policy.Read, policy.User, "_id"), nil),
+			},
+			"emailVerified": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+			"role": &graphql.Field{
+				Type: graphql.String,
+			},
+			"displayName": &graphql.Field{
+				Type: graphql.String,
+			},
+			"bio": &graphql.Field{
 				Type: graphql.String,
 			},
 			"token": &graphql.Field{
 				Type: graphql.String,
 			},
+			"shelves": &graphql.Field{
+				Type:    graphql.NewList(Shelf),
+				Resolve: resolvers.UserShelvesResolver,
+			},
 		},
 	},
 )
@@ -81,6 +146,119 @@ var Book = graphql.NewObject(
 			"title": &graphql.Field{
 				Type: graphql.String,
 			},
+			"shelfCounts": &graphql.Field{
+				Type:    ShelfCounts,
+				Resolve: resolvers.BookShelfCountsResolver,
+			},
+		},
+	},
+)
+
+var ShelfStatus = graphql.NewEnum(graphql.EnumConfig{
+	Name:        "ShelfStatus",
+	Description: "The built-in shelves. A book is on at most one of them.",
+	Values: graphql.EnumValueConfigMap{
+		resolvers.ShelfWantToRead: &graphql.EnumValueConfig{Value: resolvers.ShelfWantToRead},
+		resolvers.ShelfReading:    &graphql.EnumValueConfig{Value: resolvers.ShelfReading},
+		resolvers.ShelfRead:       &graphql.EnumValueConfig{Value: resolvers.ShelfRead},
+	},
+})
+
+var ShelfEntry = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name:        "ShelfEntry",
+		Description: "A book on one of a user's shelves.",
+		Fields: graphql.Fields{
+			"_id": &graphql.Field{
+				Type: ObjectID,
+			},
+			"book": &graphql.Field{
+				Type: Book,
+			},
+			"shelf": &graphql.Field{
+				Type: graphql.String,
+			},
+			"addedAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the book was shelved. Moving it between shelves keeps this time.",
+			},
+			"movedAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the book last moved to this shelf from another one.",
+			},
+		},
+	},
+)
+
+var Shelf = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name:        "Shelf",
+		Description: "A built-in or custom shelf. Custom shelves exist while they hold a book.",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"status": &graphql.Field{
+				Type:        ShelfStatus,
+				Description: "The status of a built-in shelf; null for custom shelves.",
+			},
+			"books": &graphql.Field{
+				Type: graphql.NewList(ShelfEntry),
+			},
+		},
+	},
+)
+
+var ShelfCounts = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name:        "ShelfCounts",
+		Description: "How many users have a book on each built-in shelf.",
+		Fields: graphql.Fields{
+			"wantToRead": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"reading": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"read": &graphql.Field{
+				Type: graphql.Int,
+			},
+		},
+	},
+)
+
+var ApiKey = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "ApiKey",
+		Fields: graphql.Fields{
+			"_id": &graphql.Field{
+				Type: ObjectID,
+			},
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"scopes": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+			"prefix": &graphql.Field{
+				Type: graphql.String,
+			},
+			"key": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The secret key. Only returned by createApiKey.",
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"expiresAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"lastUsedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"revokedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
 		},
 	},
 )
@@ -96,7 +274,7 @@ var UserInput = graphql.NewInputObject(
 				Type: graphql.String,
 			},
 			"email": &graphql.InputObjectFieldConfig{
-				Type: graphql.String,
+				Type: Email,
 			},
 		},
 	},
@@ -155,15 +333,35 @@ var ReviewInput = graphql.NewInputObject(
 	},
 )
 
+// cacheHints lists the fields whose responses may be cached. The catalog
+// changes rarely, so its reads may be served from a CDN for a short while.
+var cacheHints = httpcache.Hints{
+	"Query.books":       {MaxAge: time.Minute, Scope: httpcache.Public},
+	"Query.findBooks":   {MaxAge: time.Minute, Scope: httpcache.Public},
+	"Query.findReviews": {MaxAge: 30 * time.Second, Scope: httpcache.Public},
+	"Book.shelfCounts":  {MaxAge: 30 * time.Second, Scope: httpcache.Public},
+}
+
 func defineSchema() graphql.SchemaConfig {
 	return graphql.SchemaConfig{
+		Extensions: []graphql.Extension{metrics.Extension{}, httpcache.Extension{Hints: cacheHints}, resolvers.Loaders{}},
 		Query: graphql.NewObject(graphql.ObjectConfig{
 			Name: "Query",
 			Fields: graphql.Fields{
 				"users": &graphql.Field{
 					Name:    "users",
 					Type:    graphql.NewList(User),
-					Resolve: resolvers.UserResolver,
+					Resolve: middleware.Auth(middleware.Can(policy.List, policy.User, ""), resolvers.UserResolver),
+				},
+				"me": &graphql.Field{
+					Name:    "me",
+					Type:    User,
+					Resolve: middleware.AuthMiddleware(resolvers.MeResolver),
+				},
+				"apiKeys": &graphql.Field{
+					Name:    "apiKeys",
+					Type:    graphql.NewList(ApiKey),
+					Resolve: middleware.AuthMiddleware(resolvers.ApiKeysResolver),
 				},
 				"books": &graphql.Field{
 					Name:    "books",
@@ -224,11 +422,123 @@ func defineSchema() graphql.SchemaConfig {
 						},
 					},
 				},
+				"updateProfile": &graphql.Field{
+					Name: "updateProfile",
+					Type: User,
+					Args: graphql.FieldConfigArgument{
+						"displayName": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+						"bio": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+						"email": &graphql.ArgumentConfig{
+							Type: Email,
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.UpdateProfileResolver),
+				},
+				"changePassword": &graphql.Field{
+					Name: "changePassword",
+					Type: graphql.String,
+					Args: graphql.FieldConfigArgument{
+						"oldPassword": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"newPassword": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.ChangePasswordResolver),
+				},
+				"deleteAccount": &graphql.Field{
+					Name:        "deleteAccount",
+					Type:        graphql.Boolean,
+					Description: "Deletes the caller's account. Accounts with a password confirm with it; accounts created through single sign-on confirm with their username.",
+					Args: graphql.FieldConfigArgument{
+						"password": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+						"confirmUserName": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.DeleteAccountResolver),
+				},
+				"createApiKey": &graphql.Field{
+					Name: "createApiKey",
+					Type: ApiKey,
+					Args: graphql.FieldConfigArgument{
+						"name": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"scopes": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+						},
+						"expiresAt": &graphql.ArgumentConfig{
+							Type: graphql.DateTime,
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.CreateApiKeyResolver),
+				},
+				"revokeApiKey": &graphql.Field{
+					Name: "revokeApiKey",
+					Type: graphql.Boolean,
+					Args: graphql.FieldConfigArgument{
+						"_id": &graphql.ArgumentConfig{
+							Type: ObjectID,
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.RevokeApiKeyResolver),
+				},
+				"unlockUser": &graphql.Field{
+					Name: "unlockUser",
+					Type: graphql.Boolean,
+					Args: graphql.FieldConfigArgument{
+						"userName": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+					Resolve: middleware.Auth(middleware.Can(policy.Unlock, policy.User, ""), resolvers.UnlockUserResolver),
+				},
+				"verifyEmail": &graphql.Field{
+					Name:    "verifyEmail",
+					Type:    graphql.Boolean,
+					Resolve: resolvers.VerifyEmailResolver,
+					Args: graphql.FieldConfigArgument{
+						"token": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+				},
+				"requestPasswordReset": &graphql.Field{
+					Name:    "requestPasswordReset",
+					Type:    graphql.Boolean,
+					Resolve: resolvers.RequestPasswordResetResolver,
+					Args: graphql.FieldConfigArgument{
+						"email": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(Email),
+						},
+					},
+				},
+				"resetPassword": &graphql.Field{
+					Name:    "resetPassword",
+					Type:    graphql.Boolean,
+					Resolve: resolvers.ResetPasswordResolver,
+					Args: graphql.FieldConfigArgument{
+						"token": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"newPassword": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+				},
 
 				"addBook": &graphql.Field{
 					Name:    "addBook",
 					Type:    Book,
-					Resolve: resolvers.AddBookResolver,
+					Resolve: middleware.AuthMiddleware(resolvers.AddBookResolver),
 					Args: graphql.FieldConfigArgument{
 						"input": &graphql.ArgumentConfig{
 							Type: BookInput,
@@ -246,7 +556,7 @@ func defineSchema() graphql.SchemaConfig {
 							Type: BookInput,
 						},
 					},
-					Resolve: resolvers.UpdateBookResolver,
+					Resolve: middleware.AuthMiddleware(resolvers.UpdateBookResolver),
 				},
 				"deleteBook": &graphql.Field{
 					Name: "deleteBook",
@@ -256,7 +566,7 @@ func defineSchema() graphql.SchemaConfig {
 							Type: ObjectID,
 						},
 					},
-					Resolve: resolvers.DeleteBookResolver,
+					Resolve: middleware.AuthMiddleware(resolvers.DeleteBookResolver),
 				},
 				"addReview": &graphql.Field{
 					Name: "addReview",
@@ -292,6 +602,50 @@ func defineSchema() graphql.SchemaConfig {
 					},
 					Resolve: middleware.AuthMiddleware(resolvers.DeleteReviewResolver),
 				},
+				"addToShelf": &graphql.Field{
+					Name:        "addToShelf",
+					Type:        ShelfEntry,
+					Description: "Puts a book on a shelf. A book on another built-in shelf moves to this one if it is built-in too.",
+					Args: graphql.FieldConfigArgument{
+						"bookID": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(ObjectID),
+						},
+						"shelf": &graphql.ArgumentConfig{
+							Type:        graphql.NewNonNull(graphql.String),
+							Description: "A ShelfStatus value or the name of a custom shelf.",
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.AddToShelfResolver),
+				},
+				"moveBook": &graphql.Field{
+					Name: "moveBook",
+					Type: ShelfEntry,
+					Args: graphql.FieldConfigArgument{
+						"bookID": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(ObjectID),
+						},
+						"from": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"to": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.MoveBookResolver),
+				},
+				"removeFromShelf": &graphql.Field{
+					Name: "removeFromShelf",
+					Type: graphql.Boolean,
+					Args: graphql.FieldConfigArgument{
+						"bookID": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(ObjectID),
+						},
+						"shelf": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+					Resolve: middleware.AuthMiddleware(resolvers.RemoveFromShelfResolver),
+				},
 			},
 		}),
 	}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"grphqlserver/sdl"
+	"os"
+
+	"github.com/graphql-go/graphql"
+)
+
+const schemaUsage = `usage:
+  grphqlserver schema print [-o file]
+  grphqlserver schema diff old.graphql new.graphql`
+
+// schemaCommand runs "schema print" and "schema diff" and returns the
+// process exit code. Neither needs a database.
+func schemaCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, schemaUsage)
+		return 2
+	}
+	switch args[0] {
+	case "print":
+		return schemaPrint(args[1:])
+	case "diff":
+		return schemaDiff(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, schemaUsage)
+		return 2
+	}
+}
+
+func schemaPrint(args []string) int {
+	flags := flag.NewFlagSet("schema print", flag.ContinueOnError)
+	out := flags.String("o", "", "write the SDL to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	schema, err := graphql.NewSchema(defineSchema())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid schema:", err)
+		return 1
+	}
+	text := sdl.Print(&schema)
+
+	if *out == "" {
+		fmt.Print(text)
+		return 0
+	}
+	if err := os.WriteFile(*out, []byte(text), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// schemaDiff prints every change between two SDL files, breaking ones
+// first, and exits with 1 if any change is breaking.
+func schemaDiff(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, schemaUsage)
+		return 2
+	}
+	oldSDL, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	newSDL, err := os.ReadFile(args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	changes, err := sdl.Diff(string(oldSDL), string(newSDL))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	for _, breaking := range []bool{true, false} {
+		label := "Safe changes:"
+		if breaking {
+			label = "Breaking changes:"
+		}
+		var lines []string
+		for _, c := range changes {
+			if c.Breaking == breaking {
+				lines = append(lines, "  "+c.String())
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Println(label)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes.")
+	}
+
+	if sdl.HasBreaking(changes) {
+		return 1
+	}
+	return 0
+}
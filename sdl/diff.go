@@ -0,0 +1,289 @@
+package sdl
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Change is one difference between two schemas. Breaking changes can make
+// queries that worked against the old schema fail against the new one.
+type Change struct {
+	Breaking bool
+	// Path names what changed, such as "Query.books(limit)".
+	Path    string
+	Message string
+}
+
+func (c Change) String() string {
+	return c.Path + ": " + c.Message
+}
+
+// HasBreaking reports whether any of the changes is breaking.
+func HasBreaking(changes []Change) bool {
+	for _, c := range changes {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// typeDef is the part of a type definition that clients depend on.
+type typeDef struct {
+	kind       string
+	fields     map[string]fieldDef
+	inputs     map[string]*ast.InputValueDefinition
+	values     map[string]bool
+	members    map[string]bool
+	interfaces map[string]bool
+}
+
+type fieldDef struct {
+	typ  ast.Type
+	args map[string]*ast.InputValueDefinition
+}
+
+// Diff compares two schemas written in SDL and returns the changes from
+// oldSDL to newSDL, ordered by path.
+func Diff(oldSDL, newSDL string) ([]Change, error) {
+	oldTypes, err := parse(oldSDL)
+	if err != nil {
+		return nil, fmt.Errorf("old schema: %w", err)
+	}
+	newTypes, err := parse(newSDL)
+	if err != nil {
+		return nil, fmt.Errorf("new schema: %w", err)
+	}
+
+	d := &differ{}
+	for _, name := range union(oldTypes, newTypes) {
+		o, inOld := oldTypes[name]
+		n, inNew := newTypes[name]
+		switch {
+		case !inNew:
+			d.breaking(name, o.kind+" removed")
+		case !inOld:
+			d.safe(name, n.kind+" added")
+		case o.kind != n.kind:
+			d.breaking(name, fmt.Sprintf("changed from %s to %s", o.kind, n.kind))
+		default:
+			d.compareType(name, o, n)
+		}
+	}
+	sort.SliceStable(d.changes, func(i, j int) bool { return d.changes[i].Path < d.changes[j].Path })
+	return d.changes, nil
+}
+
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) breaking(path, message string) {
+	d.changes = append(d.changes, Change{Breaking: true, Path: path, Message: message})
+}
+
+func (d *differ) safe(path, message string) {
+	d.changes = append(d.changes, Change{Path: path, Message: message})
+}
+
+func (d *differ) compareType(name string, o, n typeDef) {
+	for _, f := range union(o.fields, n.fields) {
+		of, inOld := o.fields[f]
+		nf, inNew := n.fields[f]
+		path := name + "." + f
+		switch {
+		case !inNew:
+			d.breaking(path, "field removed")
+		case !inOld:
+			d.safe(path, "field added")
+		default:
+			if !sameType(of.typ, nf.typ) {
+				msg := fmt.Sprintf("type changed from %s to %s", typeString(of.typ), typeString(nf.typ))
+				if outputCompatible(of.typ, nf.typ) {
+					d.safe(path, msg)
+				} else {
+					d.breaking(path, msg)
+				}
+			}
+			d.compareInputs(path, "argument", of.args, nf.args)
+		}
+	}
+
+	d.compareInputs(name, "input field", o.inputs, n.inputs)
+
+	d.compareSet(name, "value", o.values, n.values)
+	d.compareSet(name, "member", o.members, n.members)
+	d.compareSet(name, "interface", o.interfaces, n.interfaces)
+}
+
+// compareInputs compares arguments or input fields. Adding a required one
+// breaks callers that don't send it; loosening a type never does.
+func (d *differ) compareInputs(parent, what string, o, n map[string]*ast.InputValueDefinition) {
+	for _, name := range union(o, n) {
+		ov, inOld := o[name]
+		nv, inNew := n[name]
+		path := parent + "." + name
+		if what == "argument" {
+			path = parent + "(" + name + ")"
+		}
+		switch {
+		case !inNew:
+			d.breaking(path, what+" removed")
+		case !inOld && required(nv):
+			d.breaking(path, "required "+what+" added")
+		case !inOld:
+			d.safe(path, what+" added")
+		case sameType(ov.Type, nv.Type):
+			if ov.DefaultValue != nil && nv.DefaultValue == nil && required(nv) {
+				d.breaking(path, what+" made required")
+			}
+		case inputCompatible(ov.Type, nv.Type):
+			d.safe(path, fmt.Sprintf("type changed from %s to %s", typeString(ov.Type), typeString(nv.Type)))
+		case !required(ov) && required(nv) && sameType(ov.Type, unwrapNonNull(nv.Type)):
+			d.breaking(path, what+" made required")
+		default:
+			d.breaking(path, fmt.Sprintf("type changed from %s to %s", typeString(ov.Type), typeString(nv.Type)))
+		}
+	}
+}
+
+func (d *differ) compareSet(parent, what string, o, n map[string]bool) {
+	for _, name := range union(o, n) {
+		switch {
+		case !n[name]:
+			d.breaking(parent+"."+name, what+" removed")
+		case !o[name]:
+			d.safe(parent+"."+name, what+" added")
+		}
+	}
+}
+
+func parse(source string) (map[string]typeDef, error) {
+	doc, err := parser.Parse(parser.ParseParams{Source: source})
+	if err != nil {
+		return nil, err
+	}
+	types := map[string]typeDef{}
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.ScalarDefinition:
+			types[def.Name.Value] = typeDef{kind: "scalar"}
+		case *ast.ObjectDefinition:
+			types[def.Name.Value] = typeDef{
+				kind:       "type",
+				fields:     fieldDefs(def.Fields),
+				interfaces: namedSet(def.Interfaces),
+			}
+		case *ast.InterfaceDefinition:
+			types[def.Name.Value] = typeDef{kind: "interface", fields: fieldDefs(def.Fields)}
+		case *ast.UnionDefinition:
+			types[def.Name.Value] = typeDef{kind: "union", members: namedSet(def.Types)}
+		case *ast.EnumDefinition:
+			values := map[string]bool{}
+			for _, v := range def.Values {
+				values[v.Name.Value] = true
+			}
+			types[def.Name.Value] = typeDef{kind: "enum", values: values}
+		case *ast.InputObjectDefinition:
+			types[def.Name.Value] = typeDef{kind: "input", inputs: inputDefs(def.Fields)}
+		}
+	}
+	return types, nil
+}
+
+func fieldDefs(fields []*ast.FieldDefinition) map[string]fieldDef {
+	m := make(map[string]fieldDef, len(fields))
+	for _, f := range fields {
+		m[f.Name.Value] = fieldDef{typ: f.Type, args: inputDefs(f.Arguments)}
+	}
+	return m
+}
+
+func inputDefs(values []*ast.InputValueDefinition) map[string]*ast.InputValueDefinition {
+	m := make(map[string]*ast.InputValueDefinition, len(values))
+	for _, v := range values {
+		m[v.Name.Value] = v
+	}
+	return m
+}
+
+func namedSet(named []*ast.Named) map[string]bool {
+	m := make(map[string]bool, len(named))
+	for _, n := range named {
+		m[n.Name.Value] = true
+	}
+	return m
+}
+
+// union returns the keys of both maps, sorted.
+func union[V any](a, b map[string]V) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]V{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func required(v *ast.InputValueDefinition) bool {
+	_, nonNull := v.Type.(*ast.NonNull)
+	return nonNull && v.DefaultValue == nil
+}
+
+func unwrapNonNull(t ast.Type) ast.Type {
+	if nn, ok := t.(*ast.NonNull); ok {
+		return nn.Type
+	}
+	return t
+}
+
+func sameType(a, b ast.Type) bool {
+	return typeString(a) == typeString(b)
+}
+
+// outputCompatible reports whether clients reading a field of type old can
+// also read new: the same type, possibly with more non-null guarantees.
+func outputCompatible(old, new ast.Type) bool {
+	switch n := new.(type) {
+	case *ast.NonNull:
+		if o, ok := old.(*ast.NonNull); ok {
+			return outputCompatible(o.Type, n.Type)
+		}
+		return outputCompatible(old, n.Type)
+	case *ast.List:
+		o, ok := old.(*ast.List)
+		return ok && outputCompatible(o.Type, n.Type)
+	case *ast.Named:
+		o, ok := old.(*ast.Named)
+		return ok && o.Name.Value == n.Name.Value
+	}
+	return false
+}
+
+// inputCompatible reports whether values sent for an argument of type old
+// are still accepted by new: the same type, possibly with fewer non-null
+// requirements.
+func inputCompatible(old, new ast.Type) bool {
+	return outputCompatible(new, old)
+}
+
+func typeString(t ast.Type) string {
+	switch t := t.(type) {
+	case *ast.NonNull:
+		return typeString(t.Type) + "!"
+	case *ast.List:
+		return "[" + typeString(t.Type) + "]"
+	case *ast.Named:
+		return t.Name.Value
+	}
+	return ""
+}
@@ -0,0 +1,205 @@
+// Package sdl prints a graphql-go schema in the GraphQL schema definition
+// language and compares two printed schemas for breaking changes.
+package sdl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// builtInScalars are defined by the GraphQL specification and are not
+// printed. graphql-go's DateTime is not among them.
+var builtInScalars = map[string]bool{
+	"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true,
+}
+
+// Print returns the schema's types in SDL, sorted by name so that the
+// output only changes when the schema does. Fields, arguments and enum
+// values are sorted by name too, as graphql-go keeps them in maps.
+func Print(schema *graphql.Schema) string {
+	var names []string
+	for name := range schema.TypeMap() {
+		if !strings.HasPrefix(name, "__") && !builtInScalars[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var blocks []string
+	if def := printSchemaDefinition(schema); def != "" {
+		blocks = append(blocks, def)
+	}
+	for _, name := range names {
+		blocks = append(blocks, printType(schema.TypeMap()[name]))
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+// printSchemaDefinition is only needed when the root types don't have
+// their conventional names.
+func printSchemaDefinition(schema *graphql.Schema) string {
+	roots := []struct {
+		op  string
+		typ *graphql.Object
+	}{
+		{"query", schema.QueryType()},
+		{"mutation", schema.MutationType()},
+		{"subscription", schema.SubscriptionType()},
+	}
+	conventional := true
+	var lines []string
+	for _, r := range roots {
+		if r.typ == nil {
+			continue
+		}
+		if r.typ.Name() != strings.ToUpper(r.op[:1])+r.op[1:] {
+			conventional = false
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", r.op, r.typ.Name()))
+	}
+	if conventional {
+		return ""
+	}
+	return "schema {\n" + strings.Join(lines, "\n") + "\n}"
+}
+
+func printType(t graphql.Type) string {
+	switch t := t.(type) {
+	case *graphql.Scalar:
+		return printDescription(t.Description(), "") + "scalar " + t.Name()
+	case *graphql.Object:
+		head := "type " + t.Name()
+		if ifaces := t.Interfaces(); len(ifaces) > 0 {
+			names := make([]string, len(ifaces))
+			for i, iface := range ifaces {
+				names[i] = iface.Name()
+			}
+			head += " implements " + strings.Join(names, " & ")
+		}
+		return printDescription(t.Description(), "") + head + printFields(t.Fields())
+	case *graphql.Interface:
+		return printDescription(t.Description(), "") + "interface " + t.Name() + printFields(t.Fields())
+	case *graphql.Union:
+		names := make([]string, len(t.Types()))
+		for i, member := range t.Types() {
+			names[i] = member.Name()
+		}
+		return printDescription(t.Description(), "") + "union " + t.Name() + " = " + strings.Join(names, " | ")
+	case *graphql.Enum:
+		values := append([]*graphql.EnumValueDefinition(nil), t.Values()...)
+		sort.Slice(values, func(i, j int) bool { return values[i].Name < values[j].Name })
+		var lines []string
+		for _, v := range values {
+			lines = append(lines, printDescription(v.Description, "  ")+"  "+v.Name+printDeprecated(v.DeprecationReason))
+		}
+		return printDescription(t.Description(), "") + "enum " + t.Name() + " {\n" + strings.Join(lines, "\n") + "\n}"
+	case *graphql.InputObject:
+		fields := t.Fields()
+		names := sortedKeys(len(fields), func(add func(string)) {
+			for name := range fields {
+				add(name)
+			}
+		})
+		var lines []string
+		for _, name := range names {
+			f := fields[name]
+			lines = append(lines, printDescription(f.Description(), "  ")+"  "+printInputValue(f.Name(), f.Type, f.DefaultValue))
+		}
+		return printDescription(t.Description(), "") + "input " + t.Name() + " {\n" + strings.Join(lines, "\n") + "\n}"
+	}
+	return ""
+}
+
+func printFields(fields graphql.FieldDefinitionMap) string {
+	names := sortedKeys(len(fields), func(add func(string)) {
+		for name := range fields {
+			add(name)
+		}
+	})
+	var lines []string
+	for _, name := range names {
+		f := fields[name]
+		line := "  " + f.Name
+		if len(f.Args) > 0 {
+			sorted := append([]*graphql.Argument(nil), f.Args...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name() < sorted[j].Name() })
+			args := make([]string, len(sorted))
+			for i, arg := range sorted {
+				args[i] = printInputValue(arg.Name(), arg.Type, arg.DefaultValue)
+			}
+			line += "(" + strings.Join(args, ", ") + ")"
+		}
+		line += ": " + f.Type.String() + printDeprecated(f.DeprecationReason)
+		lines = append(lines, printDescription(f.Description, "  ")+line)
+	}
+	return " {\n" + strings.Join(lines, "\n") + "\n}"
+}
+
+func printInputValue(name string, t graphql.Input, defaultValue interface{}) string {
+	s := name + ": " + t.String()
+	if defaultValue != nil {
+		s += " = " + printValue(t, defaultValue)
+	}
+	return s
+}
+
+// printValue writes a default value as a GraphQL literal.
+func printValue(t graphql.Type, v interface{}) string {
+	if nn, ok := t.(*graphql.NonNull); ok {
+		t = nn.OfType
+	}
+	switch t := t.(type) {
+	case *graphql.Enum:
+		for _, ev := range t.Values() {
+			if ev.Value == v {
+				return ev.Name
+			}
+		}
+	case *graphql.List:
+		if items, ok := v.([]interface{}); ok {
+			parts := make([]string, len(items))
+			for i, item := range items {
+				parts[i] = printValue(t.OfType, item)
+			}
+			return "[" + strings.Join(parts, ", ") + "]"
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func printDescription(description, indent string) string {
+	if description == "" {
+		return ""
+	}
+	if !strings.Contains(description, "\n") && !strings.Contains(description, `"`) {
+		return indent + `"""` + description + `"""` + "\n"
+	}
+	lines := strings.Split(strings.ReplaceAll(description, `"""`, `\"""`), "\n")
+	return indent + `"""` + "\n" + indent + strings.Join(lines, "\n"+indent) + "\n" + indent + `"""` + "\n"
+}
+
+func printDeprecated(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	if reason == graphql.DefaultDeprecationReason {
+		return " @deprecated"
+	}
+	b, _ := json.Marshal(reason)
+	return " @deprecated(reason: " + string(b) + ")"
+}
+
+func sortedKeys(n int, each func(add func(string))) []string {
+	keys := make([]string, 0, n)
+	each(func(k string) { keys = append(keys, k) })
+	sort.Strings(keys)
+	return keys
+}
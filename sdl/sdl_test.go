@@ -0,0 +1,139 @@
+package sdl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+func TestPrintParses(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"books": &graphql.Field{
+					Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+					Description: `Titles, "quoted"`,
+					Args: graphql.FieldConfigArgument{
+						"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+					},
+				},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := Print(&schema)
+	if !strings.Contains(text, "books(limit: Int = 10): [String!]") {
+		t.Errorf("unexpected SDL:\n%s", text)
+	}
+	changes, err := Diff(text, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Diff of identical schemas = %v", changes)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	oldSDL := `
+type Query {
+  books(title: String, limit: Int): [Book]
+  book(id: ID!): Book
+  count: Int
+}
+type Book { title: String author: String }
+input BookInput { title: String }
+enum Sort { TITLE AUTHOR }
+`
+	newSDL := `
+type Query {
+  books(title: String!, limit: Int, offset: Int, genre: String!): [Book!]
+  book(id: ID): Book
+  count: String
+  shelves: [String]
+}
+type Book { title: String! }
+input BookInput { title: String isbn: String! }
+enum Sort { TITLE YEAR }
+`
+	changes, err := Diff(oldSDL, newSDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, c := range changes {
+		got[c.String()] = c.Breaking
+	}
+	want := map[string]bool{
+		"Book.author: field removed":                       true,
+		"Book.title: type changed from String to String!":  false,
+		"BookInput.isbn: required input field added":       true,
+		"Query.book(id): type changed from ID! to ID":      false,
+		"Query.books: type changed from [Book] to [Book!]": false,
+		"Query.books(genre): required argument added":      true,
+		"Query.books(offset): argument added":              false,
+		"Query.books(title): argument made required":       true,
+		"Query.count: type changed from Int to String":     true,
+		"Query.shelves: field added":                       false,
+		"Sort.AUTHOR: value removed":                       true,
+		"Sort.YEAR: value added":                           false,
+	}
+	for change, breaking := range want {
+		b, ok := got[change]
+		if !ok {
+			t.Errorf("missing change %q", change)
+		} else if b != breaking {
+			t.Errorf("%q: breaking = %v, want %v", change, b, breaking)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d changes, want %d: %v", len(got), len(want), changes)
+	}
+	if !HasBreaking(changes) {
+		t.Error("HasBreaking = false")
+	}
+}
+
+func TestPrintIsStable(t *testing.T) {
+	status := graphql.NewEnum(graphql.EnumConfig{
+		Name: "Status",
+		Values: graphql.EnumValueConfigMap{
+			"WANT_TO_READ": &graphql.EnumValueConfig{Value: "WANT_TO_READ"},
+			"READING":      &graphql.EnumValueConfig{Value: "READING"},
+			"READ":         &graphql.EnumValueConfig{Value: "READ"},
+			"ABANDONED":    &graphql.EnumValueConfig{Value: "ABANDONED"},
+			"LENT":         &graphql.EnumValueConfig{Value: "LENT"},
+		},
+	})
+	args := graphql.FieldConfigArgument{}
+	for _, name := range []string{"to", "from", "bookID", "note", "at", "status"} {
+		args[name] = &graphql.ArgumentConfig{Type: graphql.String}
+	}
+	args["status"] = &graphql.ArgumentConfig{Type: status, DefaultValue: "READ"}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"move": &graphql.Field{Type: status, Args: args},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Print(&schema)
+	if !strings.Contains(want, "move(at: String, bookID: String, from: String, note: String, status: Status = READ, to: String): Status") ||
+		!strings.Contains(want, "  ABANDONED\n  LENT\n  READ\n  READING\n  WANT_TO_READ\n") {
+		t.Errorf("arguments or enum values not sorted by name:\n%s", want)
+	}
+	for i := 0; i < 20; i++ {
+		if got := Print(&schema); got != want {
+			t.Fatalf("Print changed between calls:\n%s\nthen:\n%s", want, got)
+		}
+	}
+}
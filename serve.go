@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"grphqlserver/apperr"
+	"grphqlserver/fixtures"
+	"grphqlserver/health"
+	"grphqlserver/httpcache"
+	"grphqlserver/logging"
+	"grphqlserver/metrics"
+	"grphqlserver/middleware"
+	"grphqlserver/resolvers"
+	"grphqlserver/sso"
+	"grphqlserver/tracing"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/handler"
+)
+
+func serveCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: grphqlserver serve")
+		return 2
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Panic("Error in configuring tracing", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if err := configure(); err != nil {
+		log.Panic("Error in configuration", err)
+	}
+
+	if migrate, err := strconv.ParseBool(os.Getenv("AUTO_MIGRATE")); err != nil || migrate {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Keep serving if this fails; readiness reports the pending migrations.
+		if _, err := resolvers.Migrate(ctx); err != nil {
+			slog.Error("error applying migrations", "error", err)
+		}
+		cancel()
+	}
+
+	// Give a fresh development database something to show.
+	if path := os.Getenv("SEED_FIXTURES"); path != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if empty, err := databaseEmpty(ctx); err != nil {
+			slog.Error("error checking for existing data", "error", err)
+		} else if empty {
+			if _, err := fixtures.LoadFile(ctx, resolvers.Store, path, fixtures.Options{}); err != nil {
+				slog.Error("error loading fixtures", "file", path, "error", err)
+			} else {
+				slog.Info("loaded fixtures", "file", path)
+			}
+		}
+		cancel()
+	}
+
+	// Playground and introspection are for development; production turns
+	// them off unless GRAPHQL_PLAYGROUND or GRAPHQL_INTROSPECTION says
+	// otherwise.
+	development := os.Getenv("APP_ENV") == "development"
+	playground := envBool("GRAPHQL_PLAYGROUND", development)
+	if !envBool("GRAPHQL_INTROSPECTION", development) {
+		graphql.SpecifiedRules = append(graphql.SpecifiedRules, middleware.NoIntrospection)
+	}
+
+	timeouts, err := middleware.TimeoutsFromEnv()
+	if err != nil {
+		log.Panic("Error in configuring GraphQL timeouts", err)
+	}
+	schema, err := newSchema(timeouts)
+	if err != nil {
+		log.Panic("Error in creating graphQL schema", err)
+	}
+	maxBody := int64(envInt("HTTP_MAX_BODY_BYTES", 1<<20))
+	http.Handle("/graphql", graphqlHandler(&schema, playground, maxBody))
+	http.Handle("/metrics", metrics.Handler())
+	metrics.TrackOperations(metrics.OperationsFromEnv())
+
+	checker := newChecker()
+	http.Handle("/healthz", health.LivenessHandler())
+	http.Handle("/readyz", checker.ReadinessHandler())
+
+	if cfg, ok := sso.ConfigFromEnv(); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := sso.New(ctx, cfg, resolvers.LinkOIDCUser)
+		cancel()
+		if err != nil {
+			log.Panic("Error in configuring OIDC login", err)
+		}
+		http.Handle("/auth/oidc/login", provider.LoginHandler())
+		http.Handle("/auth/oidc/callback", provider.CallbackHandler())
+	}
+
+	cors, err := middleware.CORSFromEnv()
+	if err != nil {
+		log.Panic("Error in configuring CORS", err)
+	}
+	if middleware.TrustedProxies, err = middleware.TrustedProxiesFromEnv(); err != nil {
+		log.Panic("Error in configuring trusted proxies", err)
+	}
+
+	csp := middleware.StrictContentSecurityPolicy
+	if playground {
+		csp = ""
+	}
+	mux := middleware.SecurityHeaders(csp, envBool("HTTP_HSTS", false),
+		middleware.CORS(cors, http.DefaultServeMux))
+
+	server := &http.Server{
+		Addr:              ":8080",
+		Handler:           tracing.Middleware(logging.Middleware(middleware.Recover(mux))),
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:    envInt("HTTP_MAX_HEADER_BYTES", 64<<10),
+	}
+
+	// On SIGTERM, report unready first so load balancers stop sending new
+	// requests, then let in-flight ones finish before closing the Mongo
+	// connections they use.
+	drained := make(chan struct{})
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		<-stop
+
+		checker.Shutdown()
+		time.Sleep(envDuration("SHUTDOWN_DELAY", 0))
+		ctx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("error shutting down the http server", "error", err)
+		}
+		if err := resolvers.Disconnect(ctx); err != nil {
+			slog.Error("error disconnecting from mongodb", "error", err)
+		}
+		close(drained)
+	}()
+
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Panic("Error when starting the http server", err)
+	}
+	<-drained
+	return 0
+}
+
+// newSchema builds the schema from defineSchema with timeouts, panic
+// recovery and tracing applied to its resolvers.
+func newSchema(timeouts middleware.Timeouts) (graphql.Schema, error) {
+	config := defineSchema()
+	config.Extensions = append(config.Extensions, middleware.OperationTimeout{Timeouts: timeouts})
+	schema, err := graphql.NewSchema(config)
+	if err != nil {
+		return schema, err
+	}
+	middleware.ApplyFieldTimeouts(&schema, timeouts)
+	middleware.RecoverResolvers(&schema)
+	tracing.InstrumentSchema(&schema)
+	return schema, nil
+}
+
+// graphqlHandler serves the schema with the per-request middleware of the
+// /graphql endpoint.
+func graphqlHandler(schema *graphql.Schema, playground bool, maxBody int64) http.Handler {
+	h := handler.New(&handler.Config{
+		Schema:     schema,
+		Pretty:     true,
+		GraphiQL:   false,
+		Playground: playground,
+		// Mask unexpected errors and add extensions.code to every error.
+		FormatErrorFn: apperr.FormatError,
+		ResultCallbackFn: func(ctx context.Context, params *graphql.Params, result *graphql.Result, body []byte) {
+			logging.ResultCallback(ctx, params, result, body)
+			metrics.ResultCallback(ctx, params, result, body)
+		},
+	})
+	return metrics.Middleware(middleware.LimitBody(maxBody, middleware.InjectHeadersMiddleware(httpcache.Middleware(h))))
+}
+
+// newChecker checks the dependencies the server needs to handle requests.
+// The readiness endpoint and the check command both run it.
+func newChecker() *health.Checker {
+	checker := health.NewChecker()
+	checker.Add("mongo", resolvers.Ping)
+	checker.Add("migrations", func(ctx context.Context) error {
+		pending, err := resolvers.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+		}
+		return nil
+	})
+	return checker
+}
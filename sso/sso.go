@@ -0,0 +1,209 @@
+// Package sso implements OpenID Connect login with the authorization code
+// flow and PKCE. After the identity provider confirms the user, the service
+// issues its own JWT exactly like loginUser does.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"grphqlserver/logging"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	loginCookie = "oidc_login"
+	loginTTL    = 10 * time.Minute
+)
+
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// ConfigFromEnv reads the OIDC_* environment variables. ok is false when
+// no issuer is configured, in which case OIDC login stays disabled.
+func ConfigFromEnv() (Config, bool) {
+	cfg := Config{
+		IssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+	}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		cfg.Scopes = strings.Fields(scopes)
+	}
+	return cfg, cfg.IssuerURL != ""
+}
+
+// Claims are the parts of the ID token used to find or create the user.
+type Claims struct {
+	Issuer            string `json:"iss"`
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Nonce             string `json:"nonce"`
+}
+
+// LinkFunc finds or creates the local user for the claims and returns a
+// token for them.
+type LinkFunc func(ctx context.Context, claims Claims) (string, error)
+
+type Provider struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	link     LinkFunc
+}
+
+// New discovers the provider's endpoints from its issuer URL.
+func New(ctx context.Context, cfg Config, link LinkFunc) (*Provider, error) {
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc client ID and redirect URL are required")
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+
+	return &Provider{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		link:     link,
+	}, nil
+}
+
+// pendingLogin is kept in a short-lived cookie between the redirect to the
+// provider and the callback.
+type pendingLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// LoginHandler redirects the browser to the identity provider.
+func (p *Provider) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pending := pendingLogin{
+			State:    randomString(),
+			Nonce:    randomString(),
+			Verifier: oauth2.GenerateVerifier(),
+		}
+		value, err := json.Marshal(pending)
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginCookie,
+			Value:    base64.RawURLEncoding.EncodeToString(value),
+			Path:     "/auth/oidc",
+			MaxAge:   int(loginTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		url := p.oauth.AuthCodeURL(pending.State,
+			oidc.Nonce(pending.Nonce),
+			oauth2.S256ChallengeOption(pending.Verifier))
+		http.Redirect(w, r, url, http.StatusFound)
+	})
+}
+
+// CallbackHandler completes the login and responds with the service's
+// own token as JSON.
+func (p *Provider) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(loginCookie)
+		if err != nil {
+			http.Error(w, "login session not found", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: "/auth/oidc", MaxAge: -1})
+
+		var pending pendingLogin
+		value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+		if err != nil || json.Unmarshal(value, &pending) != nil {
+			http.Error(w, "login session not found", http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+		if e := query.Get("error"); e != "" {
+			http.Error(w, "identity provider returned "+e, http.StatusUnauthorized)
+			return
+		}
+		if pending.State == "" || query.Get("state") != pending.State {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+
+		token, err := p.exchange(r.Context(), query.Get("code"), pending)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error completing OIDC login", "error", err)
+			http.Error(w, "login failed", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	})
+}
+
+func (p *Provider) exchange(ctx context.Context, code string, pending pendingLogin) (string, error) {
+	oauthToken, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		return "", err
+	}
+
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("no id_token in token response")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", err
+	}
+
+	var claims Claims
+	if err := idToken.Claims(&claims); err != nil {
+		return "", err
+	}
+	if claims.Nonce != pending.Nonce {
+		return "", errors.New("nonce mismatch")
+	}
+
+	return p.link(ctx, claims)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,96 @@
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"grphqlserver/sso/mockidp"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoginFlow(t *testing.T) {
+	idp := mockidp.New("graphql-service", mockidp.User{
+		Subject:       "user-1",
+		Email:         "reader@example.com",
+		EmailVerified: true,
+	})
+	defer idp.Close()
+
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	var linked Claims
+	provider, err := New(context.Background(), Config{
+		IssuerURL:   idp.URL,
+		ClientID:    "graphql-service",
+		RedirectURL: app.URL + "/auth/oidc/callback",
+	}, func(_ context.Context, claims Claims) (string, error) {
+		linked = claims
+		return "service-token", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/auth/oidc/login", provider.LoginHandler())
+	mux.Handle("/auth/oidc/callback", provider.CallbackHandler())
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	resp, err := client.Get(app.URL + "/auth/oidc/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["token"] != "service-token" {
+		t.Errorf("token = %q, want service-token", body["token"])
+	}
+	if linked.Subject != "user-1" || linked.Email != "reader@example.com" || !linked.EmailVerified {
+		t.Errorf("unexpected claims %+v", linked)
+	}
+	if linked.Issuer != idp.URL {
+		t.Errorf("issuer = %q, want %q", linked.Issuer, idp.URL)
+	}
+}
+
+func TestCallbackRejectsWrongState(t *testing.T) {
+	idp := mockidp.New("graphql-service", mockidp.User{Subject: "user-1"})
+	defer idp.Close()
+
+	provider, err := New(context.Background(), Config{
+		IssuerURL:   idp.URL,
+		ClientID:    "graphql-service",
+		RedirectURL: "http://localhost/auth/oidc/callback",
+	}, func(context.Context, Claims) (string, error) {
+		t.Error("link called for a rejected login")
+		return "", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := httptest.NewRecorder()
+	provider.LoginHandler().ServeHTTP(login, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+
+	req := httptest.NewRequest("GET", "/auth/oidc/callback?code=x&state=forged", nil)
+	for _, c := range login.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	provider.CallbackHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"grphqlserver/cache"
+	"grphqlserver/metrics"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cachedCollection serves Find from a cache keyed by the normalised filter
+// and options. Every write made through it drops all of the collection's
+// cached results, since a changed document may match any earlier query.
+type cachedCollection struct {
+	Collection
+	cache  cache.Cache
+	ttl    time.Duration
+	prefix string
+	// generation changes on every write so that a read which raced with
+	// a write doesn't store its now-stale result.
+	generation atomic.Uint64
+}
+
+// Cached wraps c with a read-through cache for Find. Other reads are
+// passed through, as they are used to check state before writing.
+func Cached(c Collection, backend cache.Cache, ttl time.Duration) Collection {
+	return &cachedCollection{Collection: c, cache: backend, ttl: ttl, prefix: "store:" + c.Name() + ":"}
+}
+
+func (c *cachedCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	key, err := c.key(filter, opts)
+	if err != nil {
+		return c.Collection.Find(ctx, filter, opts...)
+	}
+
+	if b, ok := c.cache.Get(ctx, key); ok {
+		if docs, err := splitDocuments(b); err == nil {
+			metrics.CacheLookup(c.Name(), true)
+			return mongo.NewCursorFromDocuments(docs, nil, nil)
+		}
+	}
+	metrics.CacheLookup(c.Name(), false)
+
+	generation := c.generation.Load()
+	cursor, err := c.Collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var raws []bson.Raw
+	if err := cursor.All(ctx, &raws); err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	docs := make([]interface{}, len(raws))
+	for i, raw := range raws {
+		b = append(b, raw...)
+		docs[i] = raw
+	}
+	if c.generation.Load() == generation {
+		c.cache.Set(ctx, key, b, c.ttl)
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (c *cachedCollection) invalidate(ctx context.Context) {
+	c.generation.Add(1)
+	c.cache.DeletePrefix(ctx, c.prefix)
+	metrics.CacheInvalidated(c.Name())
+}
+
+func (c *cachedCollection) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	defer c.invalidate(ctx)
+	return c.Collection.FindOneAndUpdate(ctx, filter, update, opts...)
+}
+
+func (c *cachedCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	defer c.invalidate(ctx)
+	return c.Collection.InsertOne(ctx, document, opts...)
+}
+
+func (c *cachedCollection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	defer c.invalidate(ctx)
+	return c.Collection.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (c *cachedCollection) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	defer c.invalidate(ctx)
+	return c.Collection.UpdateMany(ctx, filter, update, opts...)
+}
+
+func (c *cachedCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	defer c.invalidate(ctx)
+	return c.Collection.DeleteOne(ctx, filter, opts...)
+}
+
+func (c *cachedCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	defer c.invalidate(ctx)
+	return c.Collection.DeleteMany(ctx, filter, opts...)
+}
+
+// key identifies a query by its filter and the options that change the
+// result, with map keys sorted so equal queries get equal keys.
+func (c *cachedCollection) key(filter interface{}, opts []*options.FindOptions) (string, error) {
+	o := options.MergeFindOptions(opts...)
+	query := bson.D{
+		{Key: "filter", Value: normalize(filter)},
+		{Key: "projection", Value: normalize(o.Projection)},
+		{Key: "sort", Value: normalize(o.Sort)},
+		{Key: "skip", Value: o.Skip},
+		{Key: "limit", Value: o.Limit},
+	}
+	b, err := bson.MarshalExtJSON(query, true, false)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return c.prefix + hex.EncodeToString(sum[:]), nil
+}
+
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bson.M:
+		return normalizeMap(v)
+	case map[string]interface{}:
+		return normalizeMap(v)
+	case bson.D:
+		d := make(bson.D, len(v))
+		for i, e := range v {
+			d[i] = bson.E{Key: e.Key, Value: normalize(e.Value)}
+		}
+		return d
+	case bson.A:
+		return normalizeSlice(v)
+	case []interface{}:
+		return normalizeSlice(v)
+	case nil:
+		return bson.D{}
+	}
+	return v
+}
+
+func normalizeMap(m map[string]interface{}) bson.D {
+	d := make(bson.D, 0, len(m))
+	for k, v := range m {
+		d = append(d, bson.E{Key: k, Value: normalize(v)})
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i].Key < d[j].Key })
+	return d
+}
+
+func normalizeSlice(s []interface{}) bson.A {
+	a := make(bson.A, len(s))
+	for i, v := range s {
+		a[i] = normalize(v)
+	}
+	return a
+}
+
+// splitDocuments undoes the concatenation of BSON documents in Find.
+func splitDocuments(b []byte) ([]interface{}, error) {
+	docs := []interface{}{}
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errors.New("truncated cached document")
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 5 || n > len(b) {
+			return nil, errors.New("truncated cached document")
+		}
+		docs = append(docs, bson.Raw(b[:n]))
+		b = b[n:]
+	}
+	return docs, nil
+}
+
+type cachedStore struct {
+	Store
+	collections map[string]Collection
+}
+
+// WithCache returns a Store whose named collections are wrapped with
+// Cached, sharing one backend. Other collections are left alone.
+func WithCache(s Store, backend cache.Cache, ttl time.Duration, names ...string) Store {
+	cs := &cachedStore{Store: s, collections: make(map[string]Collection, len(names))}
+	for _, name := range names {
+		cs.collections[name] = Cached(s.Collection(name), backend, ttl)
+	}
+	return cs
+}
+
+func (s *cachedStore) Collection(name string) Collection {
+	if c, ok := s.collections[name]; ok {
+		return c
+	}
+	return s.Store.Collection(name)
+}
@@ -0,0 +1,1083 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Memory is a Store that keeps documents in process, for tests and local
+// tools. It evaluates the subset of the MongoDB query language the service
+// uses: equality, $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists,
+// $regex, $or, $and and $nor in filters; $set, $unset, $inc, $min, $max
+// and $setOnInsert in updates; top-level projections, sort, skip and
+// limit; $match and $group with $sum in aggregations. Anything else
+// returns an error rather than a wrong answer.
+type Memory struct {
+	mu          sync.Mutex
+	collections map[string][]bson.M
+	unique      map[string][][]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		collections: map[string][]bson.M{},
+		unique:      map[string][][]string{},
+	}
+}
+
+// Unique makes the fields a unique key of the collection, like a unique
+// index. Documents missing any of the fields are not indexed, as with a
+// sparse index. Violations return duplicate key errors. Like building an
+// index, it fails if stored documents already share a key.
+func (m *Memory) Unique(collection string, fields ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := &memoryCollection{m: m, name: collection}
+	m.unique[collection] = append(m.unique[collection], fields)
+	for _, doc := range m.collections[collection] {
+		if err := c.checkUnique(doc, doc); err != nil {
+			m.unique[collection] = m.unique[collection][:len(m.unique[collection])-1]
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Collection(name string) Collection {
+	return &memoryCollection{m: m, name: name}
+}
+
+func (m *Memory) Ping(context.Context) error {
+	return nil
+}
+
+func (m *Memory) Close(context.Context) error {
+	return nil
+}
+
+type memoryCollection struct {
+	m    *Memory
+	name string
+}
+
+func (c *memoryCollection) Name() string {
+	return c.name
+}
+
+func (c *memoryCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	var projection, sortSpec interface{}
+	var skip, limit int64
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Projection != nil {
+			projection = o.Projection
+		}
+		if o.Sort != nil {
+			sortSpec = o.Sort
+		}
+		if o.Skip != nil {
+			skip = *o.Skip
+		}
+		if o.Limit != nil {
+			limit = *o.Limit
+		}
+	}
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	docs, err := c.find(filter, sortSpec)
+	if err != nil {
+		return nil, err
+	}
+	if skip > int64(len(docs)) {
+		skip = int64(len(docs))
+	}
+	docs = docs[skip:]
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		if out[i], err = project(doc, projection); err != nil {
+			return nil, err
+		}
+	}
+	return mongo.NewCursorFromDocuments(out, nil, nil)
+}
+
+func (c *memoryCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	var projection, sortSpec interface{}
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Projection != nil {
+			projection = o.Projection
+		}
+		if o.Sort != nil {
+			sortSpec = o.Sort
+		}
+	}
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	docs, err := c.find(filter, sortSpec)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(docs) == 0 {
+		return errorResult(mongo.ErrNoDocuments)
+	}
+	return documentResult(docs[0], projection)
+}
+
+func (c *memoryCollection) FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	var projection, sortSpec interface{}
+	upsert, after := false, false
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if o.Projection != nil {
+			projection = o.Projection
+		}
+		if o.Sort != nil {
+			sortSpec = o.Sort
+		}
+		if o.Upsert != nil {
+			upsert = *o.Upsert
+		}
+		if o.ReturnDocument != nil {
+			after = *o.ReturnDocument == options.After
+		}
+	}
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	docs, err := c.find(filter, sortSpec)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(docs) == 0 {
+		if !upsert {
+			return errorResult(mongo.ErrNoDocuments)
+		}
+		doc, err := c.upsert(filter, update)
+		if err != nil {
+			return errorResult(err)
+		}
+		if !after {
+			return errorResult(mongo.ErrNoDocuments)
+		}
+		return documentResult(doc, projection)
+	}
+
+	before := docs[0]
+	updated, err := c.updateDoc(before, update)
+	if err != nil {
+		return errorResult(err)
+	}
+	if after {
+		return documentResult(updated, projection)
+	}
+	return documentResult(before, projection)
+}
+
+func (c *memoryCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	docs, err := c.find(filter, nil)
+	return int64(len(docs)), err
+}
+
+func (c *memoryCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	wrapped, err := toM(bson.M{"pipeline": pipeline})
+	if err != nil {
+		return nil, err
+	}
+	stages, _ := wrapped["pipeline"].(bson.A)
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	docs, err := c.find(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range stages {
+		stage, ok := s.(bson.M)
+		if !ok || len(stage) != 1 {
+			return nil, fmt.Errorf("memory store: a pipeline stage must be a document with one field")
+		}
+		for op, spec := range stage {
+			switch op {
+			case "$match":
+				filter, _ := spec.(bson.M)
+				var matched []bson.M
+				for _, doc := range docs {
+					ok, err := matches(doc, filter)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						matched = append(matched, doc)
+					}
+				}
+				docs = matched
+			case "$group":
+				if docs, err = group(docs, spec); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("memory store: unsupported pipeline stage %s", op)
+			}
+		}
+	}
+
+	out := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		out[i] = clone(doc)
+	}
+	return mongo.NewCursorFromDocuments(out, nil, nil)
+}
+
+// group evaluates a $group stage. The _id may be a field path, a document
+// of field paths or a constant; accumulators may only be $sum.
+func group(docs []bson.M, spec interface{}) ([]bson.M, error) {
+	fields, ok := spec.(bson.M)
+	if !ok {
+		return nil, fmt.Errorf("memory store: $group takes a document")
+	}
+	idSpec, ok := fields["_id"]
+	if !ok {
+		return nil, fmt.Errorf("memory store: $group requires _id")
+	}
+
+	var groups []bson.M
+	for _, doc := range docs {
+		id := groupValue(doc, idSpec)
+		var g bson.M
+		for _, candidate := range groups {
+			if equal(candidate["_id"], id) {
+				g = candidate
+				break
+			}
+		}
+		if g == nil {
+			g = bson.M{"_id": id}
+			groups = append(groups, g)
+		}
+		for name, acc := range fields {
+			if name == "_id" {
+				continue
+			}
+			a, ok := acc.(bson.M)
+			sum, isSum := a["$sum"]
+			if !ok || len(a) != 1 || !isSum {
+				return nil, fmt.Errorf("memory store: unsupported accumulator for %s", name)
+			}
+			v := groupValue(doc, sum)
+			if _, ok := number(v); !ok {
+				// $sum ignores non-numeric values.
+				v = int32(0)
+			}
+			cur, exists := g[name]
+			total, err := add(cur, exists, v)
+			if err != nil {
+				return nil, err
+			}
+			g[name] = total
+		}
+	}
+	return groups, nil
+}
+
+// groupValue evaluates a $group expression: "$path" reads a field, a
+// document evaluates each of its fields, and anything else is a constant.
+func groupValue(doc bson.M, expr interface{}) interface{} {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$") {
+			v, _ := lookup(doc, e[1:])
+			return v
+		}
+	case bson.M:
+		out := bson.M{}
+		for k, sub := range e {
+			out[k] = groupValue(doc, sub)
+		}
+		return out
+	}
+	return expr
+}
+
+func (c *memoryCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := toM(document)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	if err := c.insert(doc); err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: doc["_id"]}, nil
+}
+
+func (c *memoryCollection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.updateMatching(filter, update, false, opts)
+}
+
+func (c *memoryCollection) UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.updateMatching(filter, update, true, opts)
+}
+
+func (c *memoryCollection) updateMatching(filter, update interface{}, many bool, opts []*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	upsert := false
+	for _, o := range opts {
+		if o != nil && o.Upsert != nil {
+			upsert = *o.Upsert
+		}
+	}
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	docs, err := c.find(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 && upsert {
+		doc, err := c.upsert(filter, update)
+		if err != nil {
+			return nil, err
+		}
+		return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: doc["_id"]}, nil
+	}
+	if !many && len(docs) > 1 {
+		docs = docs[:1]
+	}
+
+	res := &mongo.UpdateResult{MatchedCount: int64(len(docs))}
+	for _, doc := range docs {
+		updated, err := c.updateDoc(doc, update)
+		if err != nil {
+			return res, err
+		}
+		if !equal(doc, updated) {
+			res.ModifiedCount++
+		}
+	}
+	return res, nil
+}
+
+func (c *memoryCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(filter, false)
+}
+
+func (c *memoryCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(filter, true)
+}
+
+func (c *memoryCollection) delete(filter interface{}, many bool) (*mongo.DeleteResult, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	var kept []bson.M
+	var deleted int64
+	for _, doc := range c.m.collections[c.name] {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok && (many || deleted == 0) {
+			deleted++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	c.m.collections[c.name] = kept
+	return &mongo.DeleteResult{DeletedCount: deleted}, nil
+}
+
+// find returns the stored documents matching filter, sorted by sortSpec
+// or else in insertion order. The caller holds the lock.
+func (c *memoryCollection) find(filter, sortSpec interface{}) ([]bson.M, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	for _, doc := range c.m.collections[c.name] {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	if sortSpec != nil {
+		keys, err := toD(sortSpec)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(docs, func(i, j int) bool {
+			for _, k := range keys {
+				a, _ := lookup(docs[i], k.Key)
+				b, _ := lookup(docs[j], k.Key)
+				n := compareValues(a, b)
+				if n == 0 {
+					continue
+				}
+				if dir, _ := number(k.Value); dir < 0 {
+					return n > 0
+				}
+				return n < 0
+			}
+			return false
+		})
+	}
+	return docs, nil
+}
+
+// insert stores doc after checking unique keys. The caller holds the lock.
+func (c *memoryCollection) insert(doc bson.M) error {
+	if err := c.checkUnique(doc, nil); err != nil {
+		return err
+	}
+	c.m.collections[c.name] = append(c.m.collections[c.name], doc)
+	return nil
+}
+
+// updateDoc replaces stored with its updated version and returns it. The
+// caller holds the lock.
+func (c *memoryCollection) updateDoc(stored bson.M, update interface{}) (bson.M, error) {
+	u, err := toM(update)
+	if err != nil {
+		return nil, err
+	}
+	updated := clone(stored)
+	if err := applyUpdate(updated, u, false); err != nil {
+		return nil, err
+	}
+	if !equal(updated["_id"], stored["_id"]) {
+		return nil, fmt.Errorf("memory store: the _id field cannot be changed")
+	}
+	if err := c.checkUnique(updated, stored); err != nil {
+		return nil, err
+	}
+	for i, doc := range c.m.collections[c.name] {
+		if isSameDoc(doc, stored) {
+			c.m.collections[c.name][i] = updated
+		}
+	}
+	return updated, nil
+}
+
+// upsert inserts the document an upsert creates: the filter's equality
+// conditions with the update applied. The caller holds the lock.
+func (c *memoryCollection) upsert(filter, update interface{}) (bson.M, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toM(update)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	for k, v := range f {
+		if strings.HasPrefix(k, "$") || isOperatorDoc(v) {
+			continue
+		}
+		setPath(doc, k, v)
+	}
+	if err := applyUpdate(doc, u, true); err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	return doc, c.insert(doc)
+}
+
+// checkUnique returns a duplicate key error if doc has the same unique key
+// as a stored document other than self.
+func (c *memoryCollection) checkUnique(doc, self bson.M) error {
+	for _, fields := range c.m.unique[c.name] {
+		key, ok := uniqueKey(doc, fields)
+		if !ok {
+			continue
+		}
+		for _, other := range c.m.collections[c.name] {
+			if self != nil && isSameDoc(other, self) {
+				continue
+			}
+			if otherKey, ok := uniqueKey(other, fields); ok && equal(key, otherKey) {
+				return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+					Code:    11000,
+					Message: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s", c.name, strings.Join(fields, "_")),
+				}}}
+			}
+		}
+	}
+	return nil
+}
+
+func uniqueKey(doc bson.M, fields []string) (bson.A, bool) {
+	key := make(bson.A, len(fields))
+	for i, f := range fields {
+		v, ok := lookup(doc, f)
+		if !ok || v == nil {
+			return nil, false
+		}
+		key[i] = v
+	}
+	return key, true
+}
+
+func isSameDoc(a, b bson.M) bool {
+	return equal(a["_id"], b["_id"])
+}
+
+func errorResult(err error) *mongo.SingleResult {
+	return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+}
+
+func documentResult(doc bson.M, projection interface{}) *mongo.SingleResult {
+	out, err := project(doc, projection)
+	if err != nil {
+		return errorResult(err)
+	}
+	return mongo.NewSingleResultFromDocument(out, nil, nil)
+}
+
+// toM converts a filter, update or document to the form stored documents
+// have, so that values compare the same way whatever Go types built them.
+func toM(v interface{}) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	err = bson.Unmarshal(data, &m)
+	return m, err
+}
+
+func toD(v interface{}) (bson.D, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	err = bson.Unmarshal(data, &d)
+	return d, err
+}
+
+func clone(doc bson.M) bson.M {
+	m, err := toM(doc)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// project applies a projection of top-level fields to a copy of doc.
+func project(doc bson.M, projection interface{}) (bson.M, error) {
+	out := clone(doc)
+	if projection == nil {
+		return out, nil
+	}
+	p, err := toM(projection)
+	if err != nil {
+		return nil, err
+	}
+
+	include := false
+	for k, v := range p {
+		if k != "_id" && truthy(v) {
+			include = true
+		}
+	}
+	if include {
+		kept := bson.M{}
+		for k, v := range p {
+			if truthy(v) {
+				if value, ok := out[k]; ok {
+					kept[k] = value
+				}
+			}
+		}
+		if v, ok := p["_id"]; !ok || truthy(v) {
+			kept["_id"] = out["_id"]
+		}
+		return kept, nil
+	}
+	for k := range p {
+		delete(out, k)
+	}
+	return out, nil
+}
+
+func truthy(v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	n, ok := number(v)
+	return ok && n != 0
+}
+
+func isOperatorDoc(v interface{}) bool {
+	m, ok := v.(bson.M)
+	if !ok || len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the value at a dotted path.
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func setPath(doc bson.M, path string, v interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(bson.M)
+		if !ok {
+			next = bson.M{}
+			doc[part] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = v
+}
+
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(bson.M)
+		if !ok {
+			return
+		}
+		doc = next
+	}
+	delete(doc, parts[len(parts)-1])
+}
+
+func matches(doc, filter bson.M) (bool, error) {
+	for k, cond := range filter {
+		var ok bool
+		var err error
+		switch k {
+		case "$or", "$and", "$nor":
+			ok, err = matchLogical(doc, k, cond)
+		default:
+			if strings.HasPrefix(k, "$") {
+				return false, fmt.Errorf("memory store: unsupported query operator %s", k)
+			}
+			v, exists := lookup(doc, k)
+			ok, err = matchField(v, exists, cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error) {
+	clauses, ok := cond.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("memory store: %s needs an array", op)
+	}
+	for _, clause := range clauses {
+		m, ok := clause.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("memory store: %s needs an array of documents", op)
+		}
+		ok, err := matches(doc, m)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$or" && ok:
+			return true, nil
+		case op == "$and" && !ok:
+			return false, nil
+		case op == "$nor" && ok:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+func matchField(v interface{}, exists bool, cond interface{}) (bool, error) {
+	ops, ok := cond.(bson.M)
+	if !ok || !isOperatorDoc(ops) {
+		return matchEqual(v, exists, cond), nil
+	}
+	for op, arg := range ops {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = matchEqual(v, exists, arg)
+		case "$ne":
+			ok = !matchEqual(v, exists, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = exists && anyElement(v, func(e interface{}) bool {
+				if !sameTypeClass(e, arg) {
+					return false
+				}
+				n := compareValues(e, arg)
+				switch op {
+				case "$gt":
+					return n > 0
+				case "$gte":
+					return n >= 0
+				case "$lt":
+					return n < 0
+				}
+				return n <= 0
+			})
+		case "$in", "$nin":
+			list, isList := arg.(bson.A)
+			if !isList {
+				return false, fmt.Errorf("memory store: %s needs an array", op)
+			}
+			for _, want := range list {
+				if matchEqual(v, exists, want) {
+					ok = true
+					break
+				}
+			}
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			ok = exists == truthy(arg)
+		case "$regex":
+			re, err := compileRegex(arg, ops["$options"])
+			if err != nil {
+				return false, err
+			}
+			ok = exists && anyElement(v, func(e interface{}) bool {
+				s, isString := e.(string)
+				return isString && re.MatchString(s)
+			})
+		case "$options":
+			continue
+		default:
+			return false, fmt.Errorf("memory store: unsupported query operator %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchEqual follows MongoDB: null matches missing fields, and a scalar
+// matches arrays containing it.
+func matchEqual(v interface{}, exists bool, want interface{}) bool {
+	if !exists {
+		return want == nil
+	}
+	if equal(v, want) {
+		return true
+	}
+	if _, wantArray := want.(bson.A); !wantArray {
+		if arr, ok := v.(bson.A); ok {
+			for _, e := range arr {
+				if equal(e, want) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func anyElement(v interface{}, f func(interface{}) bool) bool {
+	if arr, ok := v.(bson.A); ok {
+		for _, e := range arr {
+			if f(e) {
+				return true
+			}
+		}
+		return false
+	}
+	return f(v)
+}
+
+func compileRegex(pattern, opts interface{}) (*regexp.Regexp, error) {
+	var expr, flags string
+	switch p := pattern.(type) {
+	case string:
+		expr = p
+	case primitive.Regex:
+		expr, flags = p.Pattern, p.Options
+	default:
+		return nil, fmt.Errorf("memory store: $regex needs a string or regular expression")
+	}
+	if s, ok := opts.(string); ok {
+		flags += s
+	}
+	var goFlags string
+	for _, f := range flags {
+		switch f {
+		case 'i', 'm', 's':
+			goFlags += string(f)
+		}
+	}
+	if goFlags != "" {
+		expr = "(?" + goFlags + ")" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+func applyUpdate(doc, update bson.M, inserting bool) error {
+	if len(update) == 0 {
+		return fmt.Errorf("memory store: update document is empty")
+	}
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok || !strings.HasPrefix(op, "$") {
+			return fmt.Errorf("memory store: update document must only contain update operators, found %s", op)
+		}
+		for path, v := range fields {
+			cur, exists := lookup(doc, path)
+			switch op {
+			case "$set":
+				setPath(doc, path, v)
+			case "$setOnInsert":
+				if inserting {
+					setPath(doc, path, v)
+				}
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				sum, err := add(cur, exists, v)
+				if err != nil {
+					return fmt.Errorf("memory store: $inc %s: %w", path, err)
+				}
+				setPath(doc, path, sum)
+			case "$min", "$max":
+				n := compareValues(v, cur)
+				if !exists || (op == "$min" && n < 0) || (op == "$max" && n > 0) {
+					setPath(doc, path, v)
+				}
+			default:
+				return fmt.Errorf("memory store: unsupported update operator %s", op)
+			}
+		}
+	}
+	return nil
+}
+
+func add(cur interface{}, exists bool, inc interface{}) (interface{}, error) {
+	if !exists || cur == nil {
+		cur = int32(0)
+	}
+	switch a := cur.(type) {
+	case int32:
+		if b, ok := inc.(int32); ok {
+			return a + b, nil
+		}
+		if b, ok := inc.(int64); ok {
+			return int64(a) + b, nil
+		}
+	case int64:
+		switch b := inc.(type) {
+		case int32:
+			return a + int64(b), nil
+		case int64:
+			return a + b, nil
+		}
+	}
+	x, ok1 := number(cur)
+	y, ok2 := number(inc)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("cannot increment a non-numeric value")
+	}
+	return x + y, nil
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// typeOrder ranks values of different BSON types as MongoDB sorts them.
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int32, int64, float64, int:
+		return 2
+	case string:
+		return 3
+	case bson.M:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	}
+	return 12
+}
+
+// sameTypeClass reports whether $gt and friends compare a and b; MongoDB
+// only compares values of the same type class.
+func sameTypeClass(a, b interface{}) bool {
+	return typeOrder(a) == typeOrder(b)
+}
+
+func compareValues(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return ta - tb
+	}
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		y := b.(primitive.DateTime)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	if x, ok := number(a); ok {
+		y, _ := number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	if equal(a, b) {
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case bson.A:
+		y, ok := b.(bson.A)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case bson.M:
+		y, ok := b.(bson.M)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMemoryQueries(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	books := m.Collection("books")
+	now := time.Now()
+	for _, b := range []bson.M{
+		{"title": "Go in Action", "author": "Kennedy", "year": 2015, "tags": bson.A{"go"}, "addedAt": now},
+		{"title": "The Go Programming Language", "author": "Donovan", "year": 2015, "tags": bson.A{"go", "classic"}},
+		{"title": "SICP", "author": "Abelson", "year": 1985, "addedAt": now.Add(-time.Hour)},
+	} {
+		if _, err := books.InsertOne(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter interface{}
+		want   int64
+	}{
+		{"all", bson.D{}, 3},
+		{"equality", bson.M{"author": "Donovan"}, 1},
+		{"array contains", bson.M{"tags": "classic"}, 1},
+		{"null matches missing", bson.M{"tags": nil}, 1},
+		{"regex", bson.M{"title": bson.M{"$regex": primitive.Regex{Pattern: "^the go", Options: "i"}}}, 1},
+		{"regex with options", bson.M{"title": bson.M{"$regex": "go", "$options": "i"}}, 2},
+		{"range", bson.M{"year": bson.M{"$gt": 1990, "$lte": 2015}}, 2},
+		{"dates", bson.M{"addedAt": bson.M{"$gt": now.Add(-time.Minute)}}, 1},
+		{"exists", bson.M{"addedAt": bson.M{"$exists": false}}, 1},
+		{"in", bson.M{"author": bson.M{"$in": bson.A{"Abelson", "Kennedy"}}}, 2},
+		{"ne", bson.M{"author": bson.M{"$ne": "Abelson"}}, 2},
+		{"or", bson.M{"$or": bson.A{bson.M{"year": 1985}, bson.M{"author": "Kennedy"}}}, 2},
+	}
+	for _, tt := range tests {
+		n, err := books.CountDocuments(ctx, tt.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if n != tt.want {
+			t.Errorf("%s: %d documents, want %d", tt.name, n, tt.want)
+		}
+	}
+
+	if _, err := books.CountDocuments(ctx, bson.M{"title": bson.M{"$where": "true"}}); err == nil {
+		t.Error("unsupported operator was accepted")
+	}
+
+	cursor, err := books.Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "year", Value: -1}, {Key: "title", Value: 1}}).
+			SetProjection(bson.M{"title": 1, "_id": 0}).SetLimit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []bson.M
+	if err := cursor.All(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0]["title"] != "Go in Action" || got[1]["title"] != "The Go Programming Language" || len(got[0]) != 1 {
+		t.Errorf("sorted, projected and limited = %v", got)
+	}
+}
+
+func TestMemoryUpdates(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	if err := m.Unique("users", "email"); err != nil {
+		t.Fatal(err)
+	}
+	users := m.Collection("users")
+
+	res, err := users.InsertOne(ctx, bson.M{"userName": "alice", "email": "a@example.com", "bio": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.InsertedID
+	if _, err := users.InsertOne(ctx, bson.M{"userName": "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = users.InsertOne(ctx, bson.M{"userName": "eve", "email": "a@example.com"})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("duplicate insert: %v, want a duplicate key error", err)
+	}
+	_, err = users.UpdateOne(ctx, bson.M{"userName": "bob"}, bson.M{"$set": bson.M{"email": "a@example.com"}})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("duplicate update: %v, want a duplicate key error", err)
+	}
+
+	var user bson.M
+	err = users.FindOneAndUpdate(ctx, bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"tokenVersion": 1}, "$unset": bson.M{"bio": ""}, "$set": bson.M{"role": "admin"}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user["tokenVersion"] != int32(1) || user["role"] != "admin" || user["bio"] != nil {
+		t.Errorf("updated user = %v", user)
+	}
+
+	if _, err := users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"userName": "replaced"}); err == nil {
+		t.Error("replacement document was accepted as an update")
+	}
+
+	err = users.FindOne(ctx, bson.M{"userName": "nobody"}).Err()
+	if err != mongo.ErrNoDocuments {
+		t.Errorf("FindOne of a missing document: %v", err)
+	}
+
+	del, err := users.DeleteMany(ctx, bson.M{})
+	if err != nil || del.DeletedCount != 2 {
+		t.Errorf("DeleteMany = %v, %v", del, err)
+	}
+}
+
+func TestMemoryAggregate(t *testing.T) {
+	ctx := context.Background()
+	shelves := NewMemory().Collection("shelves")
+	for _, s := range []bson.M{
+		{"bookID": "gopl", "shelf": "READ"},
+		{"bookID": "gopl", "shelf": "READ"},
+		{"bookID": "gopl", "shelf": "READING"},
+		{"bookID": "sicp", "shelf": "READ"},
+		{"bookID": "sicp", "shelf": "Gifts"},
+	} {
+		if _, err := shelves.InsertOne(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cursor, err := shelves.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"shelf": bson.M{"$in": bson.A{"READ", "READING"}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"bookID": "$bookID", "shelf": "$shelf"},
+			"n":   bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		ID struct {
+			BookID string `bson:"bookID"`
+			Shelf  string `bson:"shelf"`
+		} `bson:"_id"`
+		N int64 `bson:"n"`
+	}
+	if err := cursor.All(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int64{}
+	for _, g := range got {
+		counts[g.ID.BookID+"/"+g.ID.Shelf] = g.N
+	}
+	want := map[string]int64{"gopl/READ": 2, "gopl/READING": 1, "sicp/READ": 1}
+	if len(counts) != len(want) {
+		t.Errorf("groups = %v, want %v", counts, want)
+	}
+	for k, n := range want {
+		if counts[k] != n {
+			t.Errorf("%s = %d, want %d", k, counts[k], n)
+		}
+	}
+
+	if _, err := shelves.Aggregate(ctx, bson.A{bson.M{"$lookup": bson.M{}}}); err == nil {
+		t.Error("unsupported stage was accepted")
+	}
+}
+
+func TestMemoryUniqueRejectsExistingDuplicates(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	users := m.Collection("users")
+	for _, email := range []string{"a@example.com", "a@example.com"} {
+		if _, err := users.InsertOne(ctx, bson.M{"email": email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Unique("users", "email"); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("Unique over duplicates = %v, want a duplicate key error", err)
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"email": "a@example.com"}); err != nil {
+		t.Errorf("a rejected key still applies: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package store is the data access layer under the resolvers. Resolvers
+// work with Collections, which MongoDB collections satisfy as they are, so
+// other backends and decorators such as the read cache can stand in.
+package store
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Collection is the subset of *mongo.Collection the service uses.
+type Collection interface {
+	Name() string
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+}
+
+var _ Collection = (*mongo.Collection)(nil)
+
+// Store hands out collections by name.
+type Store interface {
+	Collection(name string) Collection
+	// Ping reports whether the backend is reachable.
+	Ping(ctx context.Context) error
+	// Close releases the backend's connections once in-flight operations
+	// have finished or ctx is done.
+	Close(ctx context.Context) error
+}
+
+// Mongo is a Store backed by one MongoDB database.
+type Mongo struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+// NewMongo connects to MongoDB. The driver connects lazily, so this only
+// fails on invalid options, not on an unreachable server.
+func NewMongo(ctx context.Context, opts *options.ClientOptions, database string) (*Mongo, error) {
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Mongo{client: client, db: client.Database(database)}, nil
+}
+
+func (m *Mongo) Collection(name string) Collection {
+	return m.db.Collection(name)
+}
+
+// Database gives access to the features outside Collection, such as
+// index management in migrations.
+func (m *Mongo) Database() *mongo.Database {
+	return m.db
+}
+
+func (m *Mongo) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx, readpref.Primary())
+}
+
+func (m *Mongo) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
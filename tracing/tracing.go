@@ -0,0 +1,123 @@
+// Package tracing sets up OpenTelemetry tracing for HTTP requests,
+// GraphQL resolvers and MongoDB commands.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "grphqlserver"
+
+var tracer = otel.Tracer(instrumentationName)
+
+// Setup installs the global tracer provider and W3C trace-context
+// propagation. OTEL_TRACES_EXPORTER selects "otlp", "stdout" or "none"
+// (the default); the OTLP exporter reads the standard OTEL_EXPORTER_OTLP_*
+// variables. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL, semconv.ServiceName("graphql-service")))
+	if err != nil {
+		return nil, err
+	}
+	// Let OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	if env, err := resource.New(ctx, resource.WithFromEnv()); err == nil {
+		if merged, err := resource.Merge(res, env); err == nil {
+			res = merged
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span per HTTP request, continuing the caller's trace
+// when the request carries a traceparent header.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}))
+}
+
+// CommandMonitor starts a span for every MongoDB command, as a child of the
+// span in the command's context.
+func CommandMonitor() *event.CommandMonitor {
+	return otelmongo.NewMonitor()
+}
+
+// InstrumentSchema wraps every field that has its own resolver so that it
+// runs in a child span named after the field, such as "Query.findReviews".
+// The span's context is passed to the resolver in p.Context.
+func InstrumentSchema(schema *graphql.Schema) {
+	for _, t := range schema.TypeMap() {
+		obj, ok := t.(*graphql.Object)
+		if !ok {
+			continue
+		}
+		for name, def := range obj.Fields() {
+			if def.Resolve == nil {
+				continue
+			}
+			def.Resolve = traceResolver(obj.Name()+"."+name, def.Resolve)
+		}
+	}
+}
+
+func traceResolver(spanName string, next graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		ctx, span := tracer.Start(p.Context, spanName, trace.WithAttributes(
+			attribute.String("graphql.field.name", p.Info.FieldName),
+			attribute.String("graphql.parent.type", p.Info.ParentType.Name())))
+		defer span.End()
+
+		p.Context = ctx
+		result, err := next(p)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return result, err
+	}
+}